@@ -0,0 +1,342 @@
+package sampledb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// how many keys we look up with a single query
+const readChunkSize = 1000
+
+// how many rows we insert with a single statement
+const writeChunkSize = 500
+
+// ForeignKey is a relationship from a column of Table to the column of ReferencedTable it references
+type ForeignKey struct {
+	Table            string
+	Column           string
+	ReferencedTable  string
+	ReferencedColumn string
+}
+
+// SchemaReader describes the tables of a schema and the relationships between them
+type SchemaReader interface {
+	// Tables returns the names of the base tables of the schema
+	Tables(ctx context.Context) ([]string, error)
+	// PrimaryKey returns the primary key columns of the table in order, none if it doesn't have one
+	PrimaryKey(ctx context.Context, table string) ([]string, error)
+	// FowardRelationships returns the relationships from the table to the tables it references
+	FowardRelationships(ctx context.Context, table string) ([]ForeignKey, error)
+	// ReverseRelationships returns the relationships from the tables referencing the table to it
+	ReverseRelationships(ctx context.Context, table string) ([]ForeignKey, error)
+}
+
+// RowReader reads the rows of a schema, rows are column values by column name
+type RowReader interface {
+	// ReadRows returns the rows of the table whose columns hold one of the keys, a key has a value for each
+	// of the columns
+	ReadRows(ctx context.Context, table string, columns []string, keys [][]interface{}) ([]map[string]interface{}, error)
+	// RandomRows returns up to n randomly picked rows of the table, the same rows for the same non zero seed
+	RandomRows(ctx context.Context, table string, n int, seed int64) ([]map[string]interface{}, error)
+	// CountRows returns how many rows the table holds, an estimate will do
+	CountRows(ctx context.Context, table string) (int64, error)
+}
+
+// RowWriter writes sampled rows to a schema, files or a stream
+type RowWriter interface {
+	// WriteRows writes the rows of the table and returns how many of them were written, rows written before
+	// are skipped. Rows don't come in dependency order, a row may come before the rows it references.
+	WriteRows(ctx context.Context, table string, rows []map[string]interface{}) (int64, error)
+}
+
+// RowSource is the schema rows are sampled from
+type RowSource interface {
+	SchemaReader
+	RowReader
+}
+
+// SampleStore is a RowWriter whose rows can be read back. Appending to a sample, reporting the rows it holds
+// and dropping it when the run that created it fails need one.
+type SampleStore interface {
+	RowWriter
+	// Tables returns the names of the tables rows are written to
+	Tables(ctx context.Context) ([]string, error)
+	// ReadColumns returns the distinct values the columns hold in the rows of the table
+	ReadColumns(ctx context.Context, table string, columns []string) ([][]interface{}, error)
+	// CountRows returns how many rows the table holds
+	CountRows(ctx context.Context, table string) (int64, error)
+	// Drop removes the sample and its tables
+	Drop(ctx context.Context) error
+}
+
+// the schema of a mysql db, it reads the schema and its rows
+type mysqlSchema struct {
+	db     *sqlx.DB
+	schema string
+	// primary keys by table, they're looked up on every read
+	pksMu sync.Mutex
+	pks   map[string][]string
+}
+
+func newMysqlSchema(db *sql.DB, schema string) *mysqlSchema {
+	return &mysqlSchema{db: sqlx.NewDb(db, "mysql"), schema: schema, pks: map[string][]string{}}
+}
+
+func (m *mysqlSchema) Tables(ctx context.Context) ([]string, error) {
+	return baseTables(ctx, m.db.DB, m.schema)
+}
+
+func (m *mysqlSchema) PrimaryKey(ctx context.Context, table string) ([]string, error) {
+	m.pksMu.Lock()
+	pk, ok := m.pks[table]
+	m.pksMu.Unlock()
+	if ok {
+		return pk, nil
+	}
+	pkConstraint, err := getTablePrimaryKeyConstraints(ctx, m.db.DB, m.schema, table)
+	if err != nil {
+		return nil, err
+	}
+	m.pksMu.Lock()
+	m.pks[table] = pkConstraint.tableCol
+	m.pksMu.Unlock()
+	return pkConstraint.tableCol, nil
+}
+
+func (m *mysqlSchema) FowardRelationships(ctx context.Context, table string) ([]ForeignKey, error) {
+	return fowardRelationships(ctx, m.db.DB, m.schema, table)
+}
+
+func (m *mysqlSchema) ReverseRelationships(ctx context.Context, table string) ([]ForeignKey, error) {
+	return reverseRelationships(ctx, m.db.DB, m.schema, table)
+}
+
+// rows come ordered by primary key so runs over the same rows walk them in the same order
+func (m *mysqlSchema) ReadRows(ctx context.Context, table string, columns []string, keys [][]interface{}) ([]map[string]interface{}, error) {
+	pk, err := m.PrimaryKey(ctx, table)
+	if err != nil {
+		return nil, err
+	}
+	datas := []map[string]interface{}{}
+	for start := 0; start < len(keys); start += readChunkSize {
+		end := start + readChunkSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		q, args := makeKeysQuery(m.schema, table, columns, keys[start:end], pk)
+		rows, err := m.queryRows(ctx, q, args...)
+		if err != nil {
+			return nil, err
+		}
+		datas = append(datas, rows...)
+	}
+	return datas, nil
+}
+
+// seeded picks order the rows by a hash of the seed and their primary key, unlike RAND(seed) that doesn't
+// depend on the order the rows are scanned in
+func (m *mysqlSchema) RandomRows(ctx context.Context, table string, n int, seed int64) ([]map[string]interface{}, error) {
+	if seed == 0 {
+		return m.queryRows(ctx, fmt.Sprintf("SELECT * FROM `%s`.`%s` ORDER BY RAND() LIMIT %d;", m.schema, table, n))
+	}
+	pk, err := m.PrimaryKey(ctx, table)
+	if err != nil {
+		return nil, err
+	}
+	if len(pk) == 0 {
+		return m.queryRows(ctx, fmt.Sprintf("SELECT * FROM `%s`.`%s` ORDER BY RAND(%d) LIMIT %d;", m.schema, table, seed, n))
+	}
+	quoted := quoteColumns(pk)
+	return m.queryRows(ctx, fmt.Sprintf("SELECT * FROM `%s`.`%s` ORDER BY MD5(CONCAT_WS(',', %d, %s)), %s LIMIT %d;",
+		m.schema, table, seed, strings.Join(quoted, ", "), strings.Join(quoted, ", "), n))
+}
+
+// the engine's estimate, counting the rows of a large table would take a while
+func (m *mysqlSchema) CountRows(ctx context.Context, table string) (int64, error) {
+	var tableRows sql.NullInt64
+	err := m.db.QueryRowContext(ctx, "SELECT table_rows FROM information_schema.tables WHERE table_schema = ? AND table_name = ?;", m.schema, table).Scan(&tableRows)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return tableRows.Int64, err
+}
+
+func (m *mysqlSchema) queryRows(ctx context.Context, q string, args ...interface{}) ([]map[string]interface{}, error) {
+	rows, err := m.db.QueryxContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	datas := []map[string]interface{}{}
+	for rows.Next() {
+		rowData := make(map[string]interface{})
+		err = rows.MapScan(rowData)
+		if err != nil {
+			return nil, err
+		}
+		datas = append(datas, rowData)
+	}
+	return datas, rows.Err()
+}
+
+// returns a query selecting the rows of the table whose columns hold one of the keys, ordered by the
+// orderBy columns
+func makeKeysQuery(schema, table string, columns []string, keys [][]interface{}, orderBy []string) (string, []interface{}) {
+	quoted := quoteColumns(columns)
+	placeholder := "?"
+	if len(columns) > 1 {
+		placeholder = "(" + strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ") + ")"
+	}
+	placeholders := make([]string, len(keys))
+	args := make([]interface{}, 0, len(keys)*len(columns))
+	for i, key := range keys {
+		placeholders[i] = placeholder
+		args = append(args, key...)
+	}
+	lhs := quoted[0]
+	if len(columns) > 1 {
+		lhs = "(" + strings.Join(quoted, ", ") + ")"
+	}
+	order := ""
+	if len(orderBy) > 0 {
+		order = " ORDER BY " + strings.Join(quoteColumns(orderBy), ", ")
+	}
+	return fmt.Sprintf("SELECT * FROM `%s`.`%s` WHERE %s IN (%s)%s;", schema, table, lhs, strings.Join(placeholders, ", "), order), args
+}
+
+func quoteColumns(columns []string) []string {
+	quoted := make([]string, len(columns))
+	for i, col := range columns {
+		quoted[i] = fmt.Sprintf("`%s`", col)
+	}
+	return quoted
+}
+
+// writes rows to the tables of a mysql schema
+type mysqlWriter struct {
+	db     *sql.DB
+	schema string
+}
+
+func newMysqlWriter(db *sql.DB, schema string) *mysqlWriter {
+	return &mysqlWriter{db: db, schema: schema}
+}
+
+// writes the rows on a single connection, writeChunkSize rows a statement. Rows don't come in dependency order
+// so foreign key checks are disabled for the duration of it.
+func (w *mysqlWriter) WriteRows(ctx context.Context, table string, rows []map[string]interface{}) (int64, error) {
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	conn, err := w.db.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	_, err = conn.ExecContext(ctx, "SET foreign_key_checks = 0;")
+	if err != nil {
+		return 0, err
+	}
+	// session variables outlive the statements, restore it before the connection goes back to the pool
+	defer conn.ExecContext(context.Background(), "SET foreign_key_checks = 1;")
+	var written int64
+	for start := 0; start < len(rows); start += writeChunkSize {
+		end := start + writeChunkSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		q, args := makeInsertQuery(w.schema, table, rows[start:end])
+		res, err := conn.ExecContext(ctx, q, args...)
+		if err != nil {
+			return written, fmt.Errorf("insert into %s failed: %w", table, err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+func (w *mysqlWriter) Tables(ctx context.Context) ([]string, error) {
+	return baseTables(ctx, w.db, w.schema)
+}
+
+func (w *mysqlWriter) ReadColumns(ctx context.Context, table string, columns []string) ([][]interface{}, error) {
+	rows, err := w.db.QueryContext(ctx, fmt.Sprintf("SELECT DISTINCT %s FROM `%s`.`%s`;", strings.Join(quoteColumns(columns), ", "), w.schema, table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	vals := [][]interface{}{}
+	for rows.Next() {
+		val := make([]interface{}, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range val {
+			ptrs[i] = &val[i]
+		}
+		err = rows.Scan(ptrs...)
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, val)
+	}
+	return vals, rows.Err()
+}
+
+func (w *mysqlWriter) CountRows(ctx context.Context, table string) (int64, error) {
+	var count int64
+	err := w.db.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM `%s`.`%s`;", w.schema, table)).Scan(&count)
+	return count, err
+}
+
+func (w *mysqlWriter) Drop(ctx context.Context) error {
+	_, err := w.db.ExecContext(ctx, fmt.Sprintf("DROP DATABASE IF EXISTS `%s`;", w.schema))
+	return err
+}
+
+// returns the names of the base tables of the schema
+func baseTables(ctx context.Context, db *sql.DB, schema string) ([]string, error) {
+	tables, err := showFullTables(ctx, db, schema)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for table, tableType := range tables {
+		if tableType == "BASE TABLE" {
+			names = append(names, table)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// returns the statement inserting the rows whose key isn't in the table yet, rows missing a column insert
+// NULL into it
+func makeInsertQuery(schema, table string, rows []map[string]interface{}) (string, []interface{}) {
+	colSet := map[string]struct{}{}
+	for _, rowData := range rows {
+		for col := range rowData {
+			colSet[col] = struct{}{}
+		}
+	}
+	cols := setKeys(colSet)
+	placeholder := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", ") + ")"
+	placeholders := make([]string, len(rows))
+	args := make([]interface{}, 0, len(rows)*len(cols))
+	for i, rowData := range rows {
+		placeholders[i] = placeholder
+		for _, col := range cols {
+			args = append(args, rowData[col])
+		}
+	}
+	return fmt.Sprintf("INSERT IGNORE INTO `%s`.`%s` (%s) VALUES %s;", schema, table, strings.Join(quoteColumns(cols), ", "),
+		strings.Join(placeholders, ", ")), args
+}
@@ -0,0 +1,66 @@
+package sampledb
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// budget caps the rows and bytes a sample copies, a zero max is no cap. Rows are counted as they're
+// written so rows that were in the sample already don't count.
+type budget struct {
+	mu       sync.Mutex
+	maxRows  int64
+	maxBytes int64
+	rows     int64
+	bytes    int64
+}
+
+// take counts rows that were written
+func (b *budget) take(rows, bytes int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rows += rows
+	b.bytes += bytes
+}
+
+// fit returns how many of the leading rows fit in what's left of the budget, they're counted once written
+func (b *budget) fit(rows []map[string]interface{}) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	spentRows, spentBytes := b.rows, b.bytes
+	for i, rowData := range rows {
+		spentRows++
+		spentBytes += rowSize(rowData)
+		if b.maxRows > 0 && spentRows > b.maxRows || b.maxBytes > 0 && spentBytes > b.maxBytes {
+			return i
+		}
+	}
+	return len(rows)
+}
+
+// spent returns the rows and bytes taken so far
+func (b *budget) spent() (int64, int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.rows, b.bytes
+}
+
+// over tells if the rows that had to be taken went over the budget
+func (b *budget) over() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.maxRows > 0 && b.rows > b.maxRows || b.maxBytes > 0 && b.bytes > b.maxBytes
+}
+
+// returns the caps of the budget, for example "1000 rows and 50000 bytes"
+func (b *budget) String() string {
+	caps := []string{}
+	if b.maxRows > 0 {
+		caps = append(caps, fmt.Sprintf("%d rows", b.maxRows))
+	}
+	if b.maxBytes > 0 {
+		caps = append(caps, fmt.Sprintf("%d bytes", b.maxBytes))
+	}
+	return strings.Join(caps, " and ")
+}
@@ -0,0 +1,175 @@
+package sampledb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// how often we persist progress while sampling
+const checkpointInterval = 5 * time.Second
+
+// checkpoint is the progress of a sampling run, persisted so an interrupted run can be resumed
+type checkpoint struct {
+	TargetSchema string `json:"target_schema"`
+	SampleSchema string `json:"sample_schema"`
+	// the anchor rows by the values of their columns, the primary key of random anchors
+	Anchor struct {
+		Table   string     `json:"table"`
+		Columns []string   `json:"columns"`
+		Keys    [][]string `json:"keys"`
+	} `json:"anchor"`
+	// seed the run was started with, resumed runs pick the same rows with it
+	Seed int64 `json:"seed,omitempty"`
+	// visit keys of the rows whose relationships have been followed completely, by table
+	FowardDone map[string][]string `json:"foward_done"`
+	SampleDone map[string][]string `json:"sample_done"`
+	// rows and bytes of the budget the run had spent, a resumed run goes on from there
+	BudgetRows  int64 `json:"budget_rows,omitempty"`
+	BudgetBytes int64 `json:"budget_bytes,omitempty"`
+	// visit keys of the sampled rows that were being walked when the checkpoint was saved, they're walked
+	// again on resume. The budget let them in already, a resumed run doesn't cut them
+	Pending map[string][]string `json:"pending,omitempty"`
+	SavedAt time.Time           `json:"saved_at"`
+}
+
+func readCheckpoint(path string) (*checkpoint, error) {
+	data, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no checkpoint at %s, there's nothing to resume: %w", path, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	cp := &checkpoint{}
+	err = json.Unmarshal(data, cp)
+	if err != nil {
+		return nil, fmt.Errorf("bad checkpoint %s: %w", path, err)
+	}
+	return cp, nil
+}
+
+// restore seeds the sampler with the rows that were done when the checkpoint was saved and returns the
+// anchor params of the interrupted run
+func (s *Sampler) restore(cp *checkpoint) *sampleParams {
+	for table, keys := range cp.FowardDone {
+		for _, key := range keys {
+			s.fowardVisit.add(table, key)
+			s.fowardDone.add(table, key)
+		}
+	}
+	for table, keys := range cp.SampleDone {
+		for _, key := range keys {
+			s.sampleVisit.add(table, key)
+			s.sampleDone.add(table, key)
+		}
+	}
+	for table, keys := range cp.Pending {
+		for _, key := range keys {
+			s.pending.add(table, key)
+		}
+	}
+	s.budget.take(cp.BudgetRows, cp.BudgetBytes)
+	params := &sampleParams{table: cp.Anchor.Table, columns: cp.Anchor.Columns}
+	for _, key := range cp.Anchor.Keys {
+		vals := make([]interface{}, len(key))
+		for i, val := range key {
+			vals[i] = val
+		}
+		params.keys = append(params.keys, vals)
+	}
+	return params
+}
+
+// checkpoint saves the sampler progress if checkpoints are enabled, unless forced it's only saved
+// once every checkpointInterval
+func (s *Sampler) checkpoint(force bool) error {
+	if s.checkpointPath == "" || s.anchor == nil {
+		return nil
+	}
+	s.checkpointMu.Lock()
+	defer s.checkpointMu.Unlock()
+	if !force && time.Since(s.lastCheckpoint) < checkpointInterval {
+		return nil
+	}
+
+	cp := checkpoint{
+		TargetSchema: s.targetSchema,
+		SampleSchema: s.sampleSchema,
+		Seed:         s.opts.Seed,
+		FowardDone:   s.fowardDone.snapshot(),
+		SampleDone:   s.sampleDone.snapshot(),
+		SavedAt:      time.Now().UTC(),
+	}
+	cp.BudgetRows, cp.BudgetBytes = s.budget.spent()
+	cp.Anchor.Table, cp.Anchor.Columns = s.anchor.table, s.anchor.columns
+	for _, key := range s.anchor.keys {
+		vals := make([]string, len(key))
+		for i, val := range key {
+			vals[i] = fmt.Sprint(val)
+		}
+		cp.Anchor.Keys = append(cp.Anchor.Keys, vals)
+	}
+	if len(s.anchor.columns) == 0 {
+		cp.Anchor.Columns = []string{s.anchor.column}
+		for _, d := range s.anchor.data {
+			cp.Anchor.Keys = append(cp.Anchor.Keys, []string{fmt.Sprint(d)})
+		}
+	}
+	// rows that were visited but aren't done
+	cp.Pending = map[string][]string{}
+	for table, keys := range s.sampleVisit.snapshot() {
+		done := make(map[string]struct{}, len(cp.SampleDone[table]))
+		for _, key := range cp.SampleDone[table] {
+			done[key] = struct{}{}
+		}
+		for _, key := range keys {
+			if _, exists := done[key]; !exists {
+				cp.Pending[table] = append(cp.Pending[table], key)
+			}
+		}
+	}
+
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	// write it next to the old one and swap them so an interruption never leaves us with half a checkpoint
+	tmp, err := ioutil.TempFile(filepath.Dir(s.checkpointPath), filepath.Base(s.checkpointPath))
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	err = os.Rename(tmp.Name(), s.checkpointPath)
+	if err != nil {
+		return err
+	}
+	s.lastCheckpoint = time.Now()
+	return nil
+}
+
+// removes the checkpoint of a run that completed
+func (s *Sampler) removeCheckpoint() error {
+	if s.checkpointPath == "" {
+		return nil
+	}
+	err := os.Remove(s.checkpointPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/shopsoko/sampledb"
+)
+
+func exportCmd(args []string) error {
+	fs := newFlagSet("export", "-schema=sampleschema -targetschema=targetschema -dir=outdir -format=csv|jsonl|go|yaml|parquet")
+	conn := registerConnFlags(fs)
+	schema := fs.String("schema", "", "schema whose rows are exported, usually a sample schema")
+	targetSchema := fs.String("targetschema", "", "schema whose foreign keys order the tables in the manifest, samples don't keep them. defaults to -schema")
+	dir := fs.String("dir", "", "directory the files and manifest.json are written to, created when missing")
+	format := fs.String("format", sampledb.ExportCSV, "file format: csv, jsonl, go (structs and slice literals in a package named after -dir), yaml (go-testfixtures) or parquet")
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if *schema == "" || *dir == "" {
+		return usagef(fs, "-schema and -dir are required")
+	}
+	relSchema := *targetSchema
+	if relSchema == "" {
+		relSchema = *schema
+	}
+
+	db, err := conn.connect()
+	if err != nil {
+		return err
+	}
+	m, err := sampledb.Export(context.TODO(), db, relSchema, *schema, *dir, *format)
+	if err != nil {
+		return fmt.Errorf("could not export %s: %w", *schema, err)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "TABLE\tROWS\tFILE")
+	for _, t := range m.Tables {
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", t.Name, t.Rows, filepath.Join(*dir, t.File))
+	}
+	return tw.Flush()
+}
+
+func loadCmd(args []string) error {
+	fs := newFlagSet("load", "-from=dir -into=dsn -schema=schema -if-exists=fail|drop|reuse|truncate [-checks]")
+	conn := registerConnFlags(fs)
+	from := fs.String("from", "", "directory of an export")
+	into := fs.String("into", "", "DSN of the db to load the export to, its database names the schema. same as -dsn")
+	schema := fs.String("schema", "", "schema to load the export to, defaults to the -into database or else the schema the export was taken from")
+	ifExists := fs.String("if-exists", sampledb.IfExistsFail, "what to do when the schema exists already: fail, drop it, reuse it skipping rows it has already or truncate its tables")
+	checks := fs.Bool("checks", false, "keep foreign key checks on while loading, fails when tables reference each other in a cycle")
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if *from == "" {
+		return usagef(fs, "-from is required")
+	}
+	cfg := conn.config()
+	if *into != "" {
+		// the schema may not exist yet, connect without it
+		dsn, err := mysql.ParseDSN(*into)
+		if err != nil {
+			return usagef(fs, "invalid -into: %s", err)
+		}
+		if *schema == "" {
+			*schema = dsn.DBName
+		}
+		dsn.DBName = ""
+		cfg.DSN = dsn.FormatDSN()
+	}
+
+	db, err := sampledb.ConnectConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("could not connect to db: %w", err)
+	}
+	loaded, err := sampledb.Load(context.TODO(), db, *from, sampledb.LoadOptions{Schema: *schema, IfExists: *ifExists, Checks: *checks})
+	if err != nil {
+		return fmt.Errorf("could not load %s: %w", *from, err)
+	}
+	tables := make([]string, 0, len(loaded))
+	for table := range loaded {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "TABLE\tLOADED")
+	for _, table := range tables {
+		fmt.Fprintf(tw, "%s\t%d\n", table, loaded[table])
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopsoko/sampledb"
+)
+
+// exit codes
+const (
+	exitOK = 0
+	// the command failed
+	exitError = 1
+	// bad command or flags
+	exitUsage = 2
+	// verify found rows referencing missing rows
+	exitOrphans = 3
+)
+
+var (
+	// errUsage is returned by commands once they've printed their usage for a bad invocation
+	errUsage = errors.New("bad usage")
+	// errOrphans is returned by verify when the schema is not referentially complete
+	errOrphans = errors.New("found orphan values")
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands = []command{
+	{"sample", "copy a sample of the target schema rows to the sample schema", sampleCmd},
+	{"copy-schema", "create the tables, views, routines and triggers of the target schema in the sample schema", copySchemaCmd},
+	{"plan", "print the tables a sample would copy rows to and the relationships leading to them", planCmd},
+	{"graph", "print the foreign key relationship graph as DOT, Mermaid or JSON", graphCmd},
+	{"export", "write the rows of a schema to csv, jsonl, parquet, Go or YAML fixture files with a manifest", exportCmd},
+	{"load", "create the tables of an export in a db and insert its rows", loadCmd},
+	{"verify", "check a schema for rows referencing missing rows", verifyCmd},
+	{"refresh", "bring the rows of a sample up to date with the target schema", refreshCmd},
+}
+
+// usage: sampledb [command] [flags], the command defaults to sample
+//
+//	sampledb sample -targetschema=targetschema -sampleschema=sampleschema -anchor=table_name#col=val,val -nosample=tbl1,tbl2
+//	sampledb copy-schema -targetschema=targetschema -sampleschema=sampleschema -exclude=audit_* -foreign-keys=keep
+//	sampledb plan -targetschema=targetschema -anchor=table_name
+//	sampledb graph -targetschema=targetschema -format=dot -anchor=table_name
+//	sampledb export -schema=sampleschema -dir=outdir -format=csv
+//	sampledb load -from=outdir -into=user:pass@tcp(host:3306)/schema
+//	sampledb verify -schema=sampleschema -targetschema=targetschema
+//	sampledb refresh -targetschema=targetschema -sampleschema=sampleschema
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	if len(args) == 0 {
+		usage(os.Stderr)
+		return exitUsage
+	}
+	name := "sample"
+	switch {
+	case args[0] == "help" || args[0] == "-h" || args[0] == "-help" || args[0] == "--help":
+		usage(os.Stdout)
+		return exitOK
+	case !strings.HasPrefix(args[0], "-"):
+		name, args = args[0], args[1:]
+	}
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		err := cmd.run(args)
+		switch {
+		case err == nil, errors.Is(err, flag.ErrHelp):
+			return exitOK
+		case errors.Is(err, errUsage):
+			return exitUsage
+		case errors.Is(err, errOrphans):
+			return exitOrphans
+		default:
+			fmt.Fprintf(os.Stderr, "sampledb %s: %s\n", name, err)
+			return exitError
+		}
+	}
+	fmt.Fprintf(os.Stderr, "sampledb: unknown command %q\n\n", name)
+	usage(os.Stderr)
+	return exitUsage
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: sampledb <command> [flags], flags alone run sample")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-12s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "run sampledb <command> -h for the flags of a command")
+}
+
+// returns the flag set of a command, args describes its flags in the usage line
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: sampledb %s %s\n\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parses the command flags, the flag package prints what's wrong with them and the usage
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	err := fs.Parse(args)
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		return errUsage
+	}
+	return err
+}
+
+// prints what's wrong with the invocation and the command usage
+func usagef(fs *flag.FlagSet, format string, args ...interface{}) error {
+	fmt.Fprintf(fs.Output(), format+"\n\n", args...)
+	fs.Usage()
+	return errUsage
+}
+
+// db connection flags shared by every command, unset flags fall back to their env var
+type connFlags struct {
+	fs                                                        *flag.FlagSet
+	driver, dsn, defaultsFile, host, port, socket, user, pass *string
+	tls, charset                                              *string
+	timeout                                                   *time.Duration
+	params                                                    paramsFlag
+}
+
+func registerConnFlags(fs *flag.FlagSet) *connFlags {
+	c := &connFlags{
+		fs:           fs,
+		driver:       fs.String("driver", "mysql", "db driver"),
+		dsn:          fs.String("dsn", "", "data source name, for example user:pass@tcp(host:3306)/?timeout=5s, the other connection flags override its settings (env SAMPLEDB_DSN)"),
+		defaultsFile: fs.String("defaults-file", "", "my.cnf style option file whose [client] and [sampledb] groups are read, ~/.my.cnf when it exists"),
+		host:         fs.String("host", "", "db host, defaults to localhost (env MYSQL_HOST)"),
+		port:         fs.String("port", "", "db port, defaults to 3306 (env MYSQL_TCP_PORT)"),
+		socket:       fs.String("socket", "", "unix socket path, used instead of -host and -port (env MYSQL_UNIX_PORT)"),
+		user:         fs.String("user", "", "db user, defaults to root (env MYSQL_USER)"),
+		pass:         fs.String("pass", "", "db user pass, defaults to root. it shows up in the process list, prefer the env var or an option file (env MYSQL_PWD)"),
+		tls:          fs.String("tls", "", "TLS mode: true, false, skip-verify or preferred"),
+		charset:      fs.String("charset", "", "connection charset"),
+		timeout:      fs.Duration("timeout", 0, "dial timeout"),
+	}
+	fs.Var(&c.params, "param", "DSN parameter as name=value, can be repeated, for example -param=readTimeout=30s")
+	return c
+}
+
+func (c *connFlags) config() sampledb.ConnConfig {
+	set := map[string]struct{}{}
+	c.fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = struct{}{}
+	})
+	dsn := *c.dsn
+	if _, ok := set["dsn"]; !ok {
+		dsn = os.Getenv("SAMPLEDB_DSN")
+	}
+	_, passSet := set["pass"]
+	// the env is applied below the DSN and the flags
+	env := sampledb.ConnEnv{
+		Host:   os.Getenv("MYSQL_HOST"),
+		Port:   os.Getenv("MYSQL_TCP_PORT"),
+		Socket: os.Getenv("MYSQL_UNIX_PORT"),
+		User:   os.Getenv("MYSQL_USER"),
+	}
+	env.Pass, env.PassSet = os.LookupEnv("MYSQL_PWD")
+	cfg := sampledb.ConnConfig{
+		Driver:     *c.driver,
+		DSN:        dsn,
+		OptionFile: *c.defaultsFile,
+		Host:       *c.host,
+		Port:       *c.port,
+		Socket:     *c.socket,
+		User:       *c.user,
+		Pass:       *c.pass,
+		PassSet:    passSet,
+		Env:        env,
+		Params:     map[string]string{},
+	}
+	if _, ok := set["defaults-file"]; !ok {
+		home, err := os.UserHomeDir()
+		if err == nil {
+			if _, err := os.Stat(filepath.Join(home, ".my.cnf")); err == nil {
+				cfg.OptionFile = filepath.Join(home, ".my.cnf")
+			}
+		}
+	}
+	if *c.tls != "" {
+		cfg.Params["tls"] = *c.tls
+	}
+	if *c.charset != "" {
+		cfg.Params["charset"] = *c.charset
+	}
+	if *c.timeout != 0 {
+		cfg.Params["timeout"] = c.timeout.String()
+	}
+	for k, v := range c.params {
+		cfg.Params[k] = v
+	}
+	return cfg
+}
+
+func (c *connFlags) connect() (*sql.DB, error) {
+	db, err := sampledb.ConnectConfig(c.config())
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to db: %w", err)
+	}
+	return db, nil
+}
+
+// paramsFlag collects repeated name=value flags
+type paramsFlag map[string]string
+
+func (p *paramsFlag) String() string {
+	params := []string{}
+	for k, v := range *p {
+		params = append(params, k+"="+v)
+	}
+	sort.Strings(params)
+	return strings.Join(params, ",")
+}
+
+func (p *paramsFlag) Set(param string) error {
+	i := strings.Index(param, "=")
+	if i <= 0 {
+		return fmt.Errorf("%q is not a name=value DSN parameter", param)
+	}
+	if *p == nil {
+		*p = paramsFlag{}
+	}
+	(*p)[param[:i]] = param[i+1:]
+	return nil
+}
+
+// tableFlag collects repeated table:value flags
+type tableFlag map[string]string
+
+func (f *tableFlag) String() string {
+	vals := []string{}
+	for table, val := range *f {
+		vals = append(vals, table+":"+val)
+	}
+	sort.Strings(vals)
+	return strings.Join(vals, ",")
+}
+
+func (f *tableFlag) Set(val string) error {
+	i := strings.Index(val, ":")
+	if i <= 0 {
+		return fmt.Errorf("%q is not a table:value pair", val)
+	}
+	if *f == nil {
+		*f = tableFlag{}
+	}
+	(*f)[val[:i]] = val[i+1:]
+	return nil
+}
+
+// filterFlags narrow down the rows of -nosample tables
+type filterFlags struct {
+	where, limit tableFlag
+}
+
+func registerFilterFlags(fs *flag.FlagSet) *filterFlags {
+	f := &filterFlags{}
+	fs.Var(&f.where, "nosample-filter", "table:predicate, copy the rows of the table matching the SQL predicate instead of every row. can be repeated, for example -nosample-filter='feature_flags:active = 1'")
+	fs.Var(&f.limit, "nosample-limit", "table:N, copy the first N rows of the table in primary key order. can be repeated")
+	return f
+}
+
+// returns the filters by table, filtered tables are added to the tables copied in full
+func (f *filterFlags) filters(noSample []string) ([]string, map[string]sampledb.TableFilter, error) {
+	filters := map[string]sampledb.TableFilter{}
+	for table, where := range f.where {
+		filters[table] = sampledb.TableFilter{Where: where}
+	}
+	for table, limit := range f.limit {
+		n, err := strconv.ParseInt(limit, 10, 64)
+		if err != nil || n < 1 {
+			return nil, nil, fmt.Errorf("-nosample-limit of %s must be a positive number of rows, got %q", table, limit)
+		}
+		filter := filters[table]
+		filter.Limit = n
+		filters[table] = filter
+	}
+	full := map[string]struct{}{}
+	for _, table := range noSample {
+		full[table] = struct{}{}
+	}
+	filtered := []string{}
+	for table := range filters {
+		if _, ok := full[table]; !ok {
+			filtered = append(filtered, table)
+		}
+	}
+	sort.Strings(filtered)
+	return append(append([]string{}, noSample...), filtered...), filters, nil
+}
+
+// sizeFlag is a byte size like 500MB, units are powers of 1024
+type sizeFlag int64
+
+var sizeUnits = []struct {
+	suffix string
+	size   int64
+}{{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30}, {"TIB", 1 << 40}, {"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30}, {"TB", 1 << 40}, {"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40}, {"B", 1}}
+
+func (f *sizeFlag) String() string {
+	return strconv.FormatInt(int64(*f), 10)
+}
+
+func (f *sizeFlag) Set(size string) error {
+	num, unit := strings.ToUpper(strings.TrimSpace(size)), int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(num, u.suffix) {
+			num, unit = strings.TrimSpace(strings.TrimSuffix(num, u.suffix)), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("%q is not a size, for example 500MB", size)
+	}
+	*f = sizeFlag(n * unit)
+	return nil
+}
+
+// prints the progress of tables copied in full
+func printProgress(table string, copied, estimated int64) {
+	fmt.Fprintf(os.Stderr, "%s: copied %d of ~%d rows\n", table, copied, estimated)
+}
+
+// returns the comma separated list items
+func splitList(list string) []string {
+	if list == "" {
+		return nil
+	}
+	return strings.Split(list, ",")
+}
+
+// relationshipFlag collects repeated table.col=ref_table.col flags
+type relationshipFlag []sampledb.Relationship
+
+func (f *relationshipFlag) String() string {
+	rels := make([]string, len(*f))
+	for i, rel := range *f {
+		rels[i] = rel.Table + "." + strings.Join(rel.Columns, ",") + "=" + rel.ReferencedTable + "." + strings.Join(rel.ReferencedColumns, ",")
+	}
+	return strings.Join(rels, " ")
+}
+
+func (f *relationshipFlag) Set(val string) error {
+	rel, err := sampledb.ParseRelationship(val)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, rel)
+	return nil
+}
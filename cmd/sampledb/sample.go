@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/shopsoko/sampledb"
+)
+
+func sampleCmd(args []string) error {
+	fs := newFlagSet("sample", "-targetschema=targetschema -sampleschema=sampleschema -anchor=table_name#col=val,val -nosample=tbl1,tbl2 -nosample-filter=tbl1:predicate -nosample-limit=tbl1:N -workers=N -chunk-rows=N -max-rows=N -max-bytes=500MB -seed=N -manifest=keys.json -from-manifest=keys.json -checkpoint=file [-resume] [-append] -if-exists=fail|drop|reuse|truncate -report=report.json")
+	conn := registerConnFlags(fs)
+	targetSchema := fs.String("targetschema", "", "target schema name")
+	sampleSchema := fs.String("sampleschema", "", "sample schema name, defaults to an unused sample_db_{secs since January 1, 1970 UTC}_{random suffix}")
+	anchorTable := fs.String("anchor", "",
+		"table from which we'll start looking fot relationships, you can prepend a # followed by a list of comma separated ids after the table name to get specific "+
+			"rows only, otherwise we'll randomly select 5 rows.\nfor example: \n\t-anchor=table#column=value,value,value")
+	noSampleTable := fs.String("nosample", "", "comma separated list of tables name which will be copied in full, globs like lookup_* and regular expressions between slashes like /^ref_/ pick every table they match")
+	filterFlags := registerFilterFlags(fs)
+	workers := fs.Int("workers", 1, "number of concurrent workers used to follow relationships while sampling and to copy -nosample tables")
+	chunkRows := fs.Int("chunk-rows", 10000, "rows of -nosample tables copied by each statement")
+	checkpointPath := fs.String("checkpoint", "", "file where sampling progress is saved so an interrupted run can be resumed")
+	resume := fs.Bool("resume", false, "resume the interrupted run saved in the -checkpoint file")
+	reportPath := fs.String("report", "", "file where the statistics of the run are written as JSON")
+	maxRows := fs.Int64("max-rows", 0, "most rows the sample copies, once reached the rows referencing the sampled rows are left out. the rows they reference are always copied so it can go over")
+	var maxBytes sizeFlag
+	fs.Var(&maxBytes, "max-bytes", "most bytes of column data the sample copies, for example 500MB, like -max-rows")
+	seed := fs.Int64("seed", 0, "pick the same random anchor rows on every run when non zero, rows are read in primary key order so the same data gives the same sample")
+	manifestPath := fs.String("manifest", "", "file where the primary keys of the anchor and sampled rows are written as JSON, to take the same sample again later. read back by -append")
+	fromManifest := fs.String("from-manifest", "", "copy the rows whose primary keys the manifest lists and the rows they reference instead of sampling from -anchor")
+	appendSample := fs.Bool("append", false, "add the sampled rows to the existing -sampleschema, only its missing tables are created and rows already in it aren't copied again, same as -if-exists=reuse")
+	ifExists := fs.String("if-exists", sampledb.IfExistsFail, "what to do when -sampleschema exists already: fail, drop it, reuse it adding the sampled rows to it or truncate its tables")
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if *resume && *checkpointPath == "" {
+		return usagef(fs, "-resume requires a -checkpoint file")
+	}
+	if *appendSample {
+		*ifExists = sampledb.IfExistsReuse
+	}
+	opts := sampledb.Options{
+		TargetSchema: *targetSchema,
+		SampleSchema: *sampleSchema,
+		Workers:      *workers,
+		ChunkRows:    *chunkRows,
+		Progress:     printProgress,
+		IfExists:     *ifExists,
+		Checkpoint:   *checkpointPath,
+		Resume:       *resume,
+		MaxRows:      *maxRows,
+		MaxBytes:     int64(maxBytes),
+		Seed:         *seed,
+		Manifest:     *manifestPath,
+	}
+	switch {
+	case *fromManifest != "":
+		if *anchorTable != "" {
+			return usagef(fs, "-from-manifest and -anchor can't be used together")
+		}
+		if *resume || *checkpointPath != "" {
+			return usagef(fs, "-from-manifest can't be checkpointed, replay it again instead")
+		}
+		opts.Replay, err = sampledb.ReadSampleManifest(*fromManifest)
+		if err != nil {
+			return err
+		}
+		opts.NoSample, opts.NoSampleFilters, err = filterFlags.filters(splitList(*noSampleTable))
+		if err != nil {
+			return usagef(fs, "%s", err)
+		}
+	case !*resume:
+		if *targetSchema == "" || *anchorTable == "" {
+			return usagef(fs, "-targetschema and -anchor are required")
+		}
+		// parse anchor table params from the flag value
+		opts.Anchor, err = sampledb.ParseAnchor(*anchorTable)
+		if err != nil {
+			return usagef(fs, "%s", err)
+		}
+		opts.NoSample, opts.NoSampleFilters, err = filterFlags.filters(splitList(*noSampleTable))
+		if err != nil {
+			return usagef(fs, "%s", err)
+		}
+	}
+
+	db, err := conn.connect()
+	if err != nil {
+		return err
+	}
+	res, err := sampledb.Sample(context.TODO(), db, opts)
+	if err != nil {
+		return fmt.Errorf("could not sample db: %w", err)
+	}
+	err = res.Report.Print(os.Stdout)
+	if err != nil {
+		return fmt.Errorf("could not print report: %w", err)
+	}
+	if *reportPath != "" {
+		err = res.Report.WriteJSON(*reportPath)
+		if err != nil {
+			return fmt.Errorf("could not write report: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/shopsoko/sampledb"
+)
+
+func copySchemaCmd(args []string) error {
+	fs := newFlagSet("copy-schema", "-targetschema=targetschema -sampleschema=sampleschema -nosample=tbl1,tbl2 -nosample-filter=tbl1:predicate -nosample-limit=tbl1:N -workers=N -chunk-rows=N -include=tbl1,tbl2 -exclude=tbl1,tbl2 -reset-auto-increment -foreign-keys=drop|keep -strip-partitions -if-exists=fail|drop|reuse|truncate")
+	conn := registerConnFlags(fs)
+	targetSchema := fs.String("targetschema", "", "schema to copy")
+	sampleSchema := fs.String("sampleschema", "", "schema to create")
+	noSampleTable := fs.String("nosample", "", "comma separated list of tables name whose rows are copied as well, globs like lookup_* and regular expressions between slashes like /^ref_/ pick every table they match")
+	filterFlags := registerFilterFlags(fs)
+	workers := fs.Int("workers", 1, "number of -nosample table chunks copied concurrently")
+	chunkRows := fs.Int("chunk-rows", 10000, "rows of -nosample tables copied by each statement")
+	include := fs.String("include", "", "comma separated list of the tables and views created, all of them by default. globs and regular expressions between slashes pick every table they match")
+	exclude := fs.String("exclude", "", "comma separated list of the tables and views left out, globs and regular expressions between slashes pick every table they match")
+	resetAutoIncrement := fs.Bool("reset-auto-increment", true, "start the AUTO_INCREMENT counters of the sample tables over instead of keeping the target ones")
+	foreignKeys := fs.String("foreign-keys", "drop", "what to do with the foreign keys of the target tables: drop them or keep them")
+	stripPartitions := fs.Bool("strip-partitions", false, "create the sample tables without the partitioning of the target tables")
+	ifExists := fs.String("if-exists", sampledb.IfExistsFail, "what to do when -sampleschema exists already: fail, drop it, reuse it creating the tables it's missing or truncate its tables")
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if *targetSchema == "" || *sampleSchema == "" {
+		return usagef(fs, "-targetschema and -sampleschema are required")
+	}
+	if *foreignKeys != "drop" && *foreignKeys != "keep" {
+		return usagef(fs, "unknown -foreign-keys %s, want drop or keep", *foreignKeys)
+	}
+
+	db, err := conn.connect()
+	if err != nil {
+		return err
+	}
+	noSample, filters, err := filterFlags.filters(splitList(*noSampleTable))
+	if err != nil {
+		return usagef(fs, "%s", err)
+	}
+	noSmplTbls := map[string]struct{}{}
+	for _, tbl := range noSample {
+		noSmplTbls[tbl] = struct{}{}
+	}
+	copyOpts := sampledb.CopyOptions{
+		ChunkRows:         *chunkRows,
+		Workers:           *workers,
+		Progress:          printProgress,
+		Filters:           filters,
+		Include:           splitList(*include),
+		Exclude:           splitList(*exclude),
+		KeepAutoIncrement: !*resetAutoIncrement,
+		KeepForeignKeys:   *foreignKeys == "keep",
+		StripPartitions:   *stripPartitions,
+	}
+	err = sampledb.CopySchemaWithOptions(context.TODO(), db, *targetSchema, *sampleSchema, noSmplTbls, *ifExists, copyOpts)
+	if err != nil {
+		return fmt.Errorf("could not copy schema: %w", err)
+	}
+	err = sampledb.CopyTriggers(context.TODO(), db, *targetSchema, *sampleSchema)
+	if err != nil {
+		return fmt.Errorf("could not copy triggers: %w", err)
+	}
+	err = sampledb.CopyEvents(context.TODO(), db, *targetSchema, *sampleSchema)
+	if err != nil {
+		return fmt.Errorf("could not copy events: %w", err)
+	}
+	return nil
+}
+
+func planCmd(args []string) error {
+	fs := newFlagSet("plan", "-targetschema=targetschema -anchor=table_name -nosample=tbl1,tbl2 -nosample-filter=tbl1:predicate -nosample-limit=tbl1:N [-json]")
+	conn := registerConnFlags(fs)
+	targetSchema := fs.String("targetschema", "", "target schema name")
+	anchorTable := fs.String("anchor", "", "table the sample starts from, in the same format as the sample command takes it")
+	noSampleTable := fs.String("nosample", "", "comma separated list of tables name which will be copied in full, globs like lookup_* and regular expressions between slashes like /^ref_/ pick every table they match")
+	filterFlags := registerFilterFlags(fs)
+	asJSON := fs.Bool("json", false, "print the plan as JSON")
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if *targetSchema == "" || *anchorTable == "" {
+		return usagef(fs, "-targetschema and -anchor are required")
+	}
+	anchor, err := sampledb.ParseAnchor(*anchorTable)
+	if err != nil {
+		return usagef(fs, "%s", err)
+	}
+	noSample, filters, err := filterFlags.filters(splitList(*noSampleTable))
+	if err != nil {
+		return usagef(fs, "%s", err)
+	}
+
+	db, err := conn.connect()
+	if err != nil {
+		return err
+	}
+	plan, err := sampledb.PlanSample(context.TODO(), db, sampledb.Options{TargetSchema: *targetSchema, Anchor: anchor, NoSample: noSample, NoSampleFilters: filters})
+	if err != nil {
+		return fmt.Errorf("could not plan sample: %w", err)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(plan)
+	}
+	return plan.Print(os.Stdout)
+}
+
+func graphCmd(args []string) error {
+	fs := newFlagSet("graph", "-targetschema=targetschema -format=dot|mermaid|json -anchor=table_name -nosample=tbl1,tbl2 [-reachable]")
+	conn := registerConnFlags(fs)
+	targetSchema := fs.String("targetschema", "", "target schema name")
+	format := fs.String("format", sampledb.GraphDOT, "output format: dot, mermaid or json")
+	anchorTable := fs.String("anchor", "", "highlight the tables and relationships a sample from this table reaches")
+	noSampleTable := fs.String("nosample", "", "comma separated list of tables name which will be copied in full, along with -anchor. globs and regular expressions between slashes pick every table they match")
+	reachable := fs.Bool("reachable", false, "only output the tables and relationships -anchor reaches")
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if *targetSchema == "" {
+		return usagef(fs, "-targetschema is required")
+	}
+	if *reachable && *anchorTable == "" {
+		return usagef(fs, "-reachable requires an -anchor")
+	}
+	switch *format {
+	case sampledb.GraphDOT, sampledb.GraphMermaid, sampledb.GraphJSON:
+	default:
+		return usagef(fs, "unknown -format %s", *format)
+	}
+	opts := sampledb.Options{TargetSchema: *targetSchema, NoSample: splitList(*noSampleTable)}
+	if *anchorTable != "" {
+		opts.Anchor, err = sampledb.ParseAnchor(*anchorTable)
+		if err != nil {
+			return usagef(fs, "%s", err)
+		}
+	}
+
+	db, err := conn.connect()
+	if err != nil {
+		return err
+	}
+	g, err := sampledb.SchemaGraph(context.TODO(), db, opts, *reachable)
+	if err != nil {
+		return fmt.Errorf("could not read relationship graph: %w", err)
+	}
+	return g.Write(os.Stdout, *format)
+}
+
+func verifyCmd(args []string) error {
+	fs := newFlagSet("verify", "-schema=sampleschema -targetschema=targetschema -fk=table.col=ref_table.col")
+	conn := registerConnFlags(fs)
+	schema := fs.String("schema", "", "schema to verify")
+	targetSchema := fs.String("targetschema", "", "schema whose foreign keys are checked, samples don't keep them. defaults to -schema")
+	var virtual relationshipFlag
+	fs.Var(&virtual, "fk", "table.col=ref_table.col, a virtual foreign key the schema doesn't declare checked as well. composite keys list their columns as table.a,b=ref_table.a,b. can be repeated")
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if *schema == "" {
+		return usagef(fs, "-schema is required")
+	}
+	relSchema := *targetSchema
+	if relSchema == "" {
+		relSchema = *schema
+	}
+
+	db, err := conn.connect()
+	if err != nil {
+		return err
+	}
+	orphans, err := sampledb.Verify(context.TODO(), db, relSchema, *schema, virtual...)
+	if err != nil {
+		return fmt.Errorf("could not verify schema: %w", err)
+	}
+	for _, o := range orphans {
+		fmt.Printf("%s.%s = %s references missing %s.%s\n", o.Table, o.Column, o.Value, o.ReferencedTable, o.ReferencedColumn)
+	}
+	if len(orphans) > 0 {
+		fmt.Fprintf(os.Stderr, "%s is not referentially complete, found %d orphan values\n", *schema, len(orphans))
+		return errOrphans
+	}
+	return nil
+}
+
+func refreshCmd(args []string) error {
+	fs := newFlagSet("refresh", "-targetschema=targetschema -sampleschema=sampleschema")
+	conn := registerConnFlags(fs)
+	targetSchema := fs.String("targetschema", "", "schema the sample was taken from")
+	sampleSchema := fs.String("sampleschema", "", "sample schema to refresh")
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if *targetSchema == "" || *sampleSchema == "" {
+		return usagef(fs, "-targetschema and -sampleschema are required")
+	}
+
+	db, err := conn.connect()
+	if err != nil {
+		return err
+	}
+	err = sampledb.Refresh(context.TODO(), db, *targetSchema, *sampleSchema)
+	if err != nil {
+		return fmt.Errorf("could not refresh sample: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+package sampledb
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// option file groups we read, later groups override earlier ones
+var optionGroups = map[string]struct{}{"client": {}, "sampledb": {}}
+
+// ConnConfig is how to connect to the db server. Each setting is taken from, in increasing priority,
+// the defaults (localhost:3306 as root with password root), the option file, Env, the DSN, the fields and
+// Params.
+type ConnConfig struct {
+	// db driver, defaults to mysql
+	Driver string
+	// go-sql-driver/mysql DSN, for example user:pass@tcp(host:3306)/?timeout=5s&tls=true
+	DSN string
+	// my.cnf style option file, its [client] and [sampledb] groups are read
+	OptionFile string
+	Host       string
+	Port       string
+	// unix socket path, connects through it instead of Host and Port
+	Socket string
+	User   string
+	Pass   string
+	// Pass is used even when empty, to connect without a password
+	PassSet bool
+	// settings from the environment, they don't override the DSN
+	Env ConnEnv
+	// DSN parameters such as timeout, readTimeout, tls, charset or collation
+	Params map[string]string
+}
+
+// ConnEnv is the connection settings of the environment, like the MYSQL_HOST, MYSQL_TCP_PORT,
+// MYSQL_UNIX_PORT, MYSQL_USER and MYSQL_PWD env vars of the mysql client. Empty ones are unset.
+type ConnEnv struct {
+	Host   string
+	Port   string
+	Socket string
+	User   string
+	Pass   string
+	// Pass is used even when empty
+	PassSet bool
+}
+
+// layered settings of a connection, empty ones are unset
+type connSettings struct {
+	net, addr, user, pass, dbName string
+	params                        map[string]string
+}
+
+// Connect opens a connection pool to the db server
+func Connect(driver, host, port, user, pass string) (*sql.DB, error) {
+	return ConnectConfig(ConnConfig{Driver: driver, Host: host, Port: port, User: user, Pass: pass})
+}
+
+// ConnectConfig opens a connection pool to the db server cfg describes
+func ConnectConfig(cfg ConnConfig) (*sql.DB, error) {
+	dsn, err := cfg.FormatDSN()
+	if err != nil {
+		return nil, err
+	}
+	driver := cfg.Driver
+	if driver == "" {
+		driver = "mysql"
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetConnMaxLifetime(5 * time.Second)
+	db.SetMaxOpenConns(100)
+	db.SetMaxIdleConns(100)
+	return db, db.Ping()
+}
+
+// FormatDSN returns the DSN the settings of cfg add up to. Multi statements are always enabled, copying
+// the schema relies on them.
+func (cfg ConnConfig) FormatDSN() (string, error) {
+	s := connSettings{net: "tcp", addr: "localhost:3306", user: "root", pass: "root", params: map[string]string{}}
+	if cfg.OptionFile != "" {
+		err := s.applyOptionFile(cfg.OptionFile)
+		if err != nil {
+			return "", err
+		}
+	}
+	env := cfg.Env
+	s.applyFields(env.Host, env.Port, env.Socket, env.User, env.Pass, env.PassSet)
+	if cfg.DSN != "" {
+		err := s.applyDSN(cfg.DSN)
+		if err != nil {
+			return "", err
+		}
+	}
+	s.applyFields(cfg.Host, cfg.Port, cfg.Socket, cfg.User, cfg.Pass, cfg.PassSet)
+	for k, v := range cfg.Params {
+		s.params[k] = v
+	}
+	return s.format()
+}
+
+// applies the settings that are set, an empty pass is set when passSet is
+func (s *connSettings) applyFields(host, port, socket, user, pass string, passSet bool) {
+	if host != "" || port != "" {
+		h, p := "localhost", "3306"
+		if s.net == "tcp" {
+			h, p = splitHostPort(s.addr)
+		}
+		if host != "" {
+			h = host
+		}
+		if port != "" {
+			p = port
+		}
+		s.net, s.addr = "tcp", net.JoinHostPort(h, p)
+	}
+	if socket != "" {
+		s.net, s.addr = "unix", socket
+	}
+	if user != "" {
+		s.user = user
+	}
+	if pass != "" || passSet {
+		s.pass = pass
+	}
+}
+
+func (s *connSettings) applyDSN(dsn string) error {
+	parsed, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return fmt.Errorf("invalid dsn: %w", err)
+	}
+	// the driver fills in a default address, only keep the one the dsn names
+	query := ""
+	if i := strings.LastIndex(dsn, "?"); i >= 0 && i > strings.LastIndex(dsn, "/") {
+		dsn, query = dsn[:i], dsn[i+1:]
+	}
+	if i := strings.LastIndex(dsn, "/"); i >= 0 && strings.Contains(dsn[:i], "(") {
+		s.net, s.addr = parsed.Net, parsed.Addr
+	}
+	if parsed.User != "" {
+		s.user = parsed.User
+	}
+	if strings.Contains(dsn, "@") && strings.Contains(dsn[:strings.LastIndex(dsn, "@")], ":") {
+		s.pass = parsed.Passwd
+	}
+	if parsed.DBName != "" {
+		s.dbName = parsed.DBName
+	}
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return fmt.Errorf("invalid dsn params: %w", err)
+	}
+	for k, v := range params {
+		s.params[k] = v[len(v)-1]
+	}
+	return nil
+}
+
+// applies the connection options of the [client] and [sampledb] groups of a my.cnf style file
+func (s *connSettings) applyOptionFile(path string) error {
+	opts, err := readOptionFile(path)
+	if err != nil {
+		return err
+	}
+	host, port := splitHostPort(s.addr)
+	for _, opt := range opts {
+		switch opt[0] {
+		case "host":
+			s.net, host = "tcp", opt[1]
+		case "port":
+			s.net, port = "tcp", opt[1]
+		case "socket":
+			s.net, s.addr = "unix", opt[1]
+		case "user":
+			s.user = opt[1]
+		case "password":
+			s.pass = opt[1]
+		case "database":
+			s.dbName = opt[1]
+		case "default_character_set":
+			s.params["charset"] = opt[1]
+		case "connect_timeout":
+			s.params["timeout"] = opt[1] + "s"
+		case "ssl_mode":
+			tls, ok := map[string]string{
+				"DISABLED": "false", "PREFERRED": "preferred", "REQUIRED": "skip-verify", "VERIFY_CA": "true", "VERIFY_IDENTITY": "true",
+			}[strings.ToUpper(opt[1])]
+			if !ok {
+				return fmt.Errorf("%s: unknown ssl-mode %s", path, opt[1])
+			}
+			s.params["tls"] = tls
+		}
+		if s.net == "tcp" {
+			s.addr = net.JoinHostPort(host, port)
+		}
+	}
+	return nil
+}
+
+// readOptionFile returns the options of the groups we read as name, value pairs in the order they're set.
+// Dashes in option names are read as underscores, like the mysql client does.
+func readOptionFile(path string) ([][2]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	opts := [][2]string{}
+	inGroup := false
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';' || line[0] == '!':
+			continue
+		case line[0] == '[':
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("%s:%d: invalid group %s", path, n, line)
+			}
+			_, inGroup = optionGroups[strings.ToLower(strings.TrimSpace(line[1:len(line)-1]))]
+			continue
+		case !inGroup:
+			continue
+		}
+		name, value := line, ""
+		if i := strings.Index(line, "="); i >= 0 {
+			name, value = strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		}
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		} else if i := strings.Index(value, " #"); i >= 0 {
+			value = strings.TrimSpace(value[:i])
+		}
+		opts = append(opts, [2]string{strings.ReplaceAll(strings.ToLower(name), "-", "_"), value})
+	}
+	return opts, sc.Err()
+}
+
+func (s *connSettings) format() (string, error) {
+	cfg := mysql.NewConfig()
+	cfg.Net, cfg.Addr, cfg.User, cfg.Passwd, cfg.DBName = s.net, s.addr, s.user, s.pass, s.dbName
+	dsn := cfg.FormatDSN()
+	keys := []string{}
+	for k := range s.params {
+		if k != "multiStatements" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	params := []string{"multiStatements=true"}
+	for _, k := range keys {
+		params = append(params, k+"="+url.QueryEscape(s.params[k]))
+	}
+	dsn += "?" + strings.Join(params, "&")
+	// let the driver reject invalid params before we connect
+	_, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "", fmt.Errorf("invalid dsn: %w", err)
+	}
+	return dsn, nil
+}
+
+func splitHostPort(addr string) (string, string) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr, "3306"
+	}
+	return host, port
+}
@@ -0,0 +1,82 @@
+package sampledb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	autoIncrementRE = regexp.MustCompile(`\sAUTO_INCREMENT=\d+`)
+	foreignKeyRE    = regexp.MustCompile("^\\s*(?:CONSTRAINT\\s+`[^`]*`\\s+)?FOREIGN KEY\\b")
+	// references to tables of the same schema aren't qualified
+	unqualifiedRefRE = regexp.MustCompile("\\bREFERENCES\\s+(`[^`]*`)\\s*\\(")
+	// the schema, when qualified, and table a foreign key references
+	referencedTableRE = regexp.MustCompile("\\bREFERENCES\\s+(?:`([^`]*)`\\.)?`([^`]*)`")
+)
+
+// creates the sample table like the target one, from its CREATE TABLE statement when opts keep more of
+// it than CREATE TABLE LIKE does or strip its partitions. tables are the tables of the sample schema.
+func createTable(ctx context.Context, db *sql.DB, tx *sql.Tx, targetSchema, sampleSchema, table string, tables map[string]struct{}, opts CopyOptions) error {
+	if !opts.KeepAutoIncrement && !opts.KeepForeignKeys && !opts.StripPartitions {
+		// LIKE resets the counters and leaves the foreign keys out
+		_, err := tx.ExecContext(ctx, fmt.Sprintf("CREATE TABLE `%s`.`%s` LIKE `%s`.`%s`;", sampleSchema, table, targetSchema, table))
+		return err
+	}
+	obj := schemaObject{kind: "TABLE", name: table}
+	err := showCreate(ctx, db, targetSchema, &obj)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, rewriteCreateTable(obj.create, targetSchema, sampleSchema, tables, opts))
+	return err
+}
+
+// rewrites the CREATE TABLE statement of a target table to create it in the sample schema, dropping what
+// opts doesn't keep. Kept foreign keys to target tables that aren't among the sample tables are dropped too.
+func rewriteCreateTable(stmt, targetSchema, sampleSchema string, tables map[string]struct{}, opts CopyOptions) string {
+	stmt = strings.Replace(stmt, "CREATE TABLE `", "CREATE TABLE `"+sampleSchema+"`.`", 1)
+	if opts.StripPartitions {
+		for _, partitions := range []string{"\n/*!50100 PARTITION BY", "\n/*!50500 PARTITION BY", "\nPARTITION BY"} {
+			if i := strings.Index(stmt, partitions); i >= 0 {
+				stmt = stmt[:i]
+			}
+		}
+	}
+	if !opts.KeepAutoIncrement {
+		stmt = autoIncrementRE.ReplaceAllString(stmt, "")
+	}
+	lines := strings.Split(stmt, "\n")
+	kept := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if foreignKeyRE.MatchString(line) && !keepForeignKey(line, targetSchema, tables, opts) {
+			continue
+		}
+		// the definition before the closing parenthesis can't end with a comma
+		if strings.HasPrefix(line, ")") && len(kept) > 0 {
+			kept[len(kept)-1] = strings.TrimSuffix(kept[len(kept)-1], ",")
+		}
+		kept = append(kept, line)
+	}
+	stmt = strings.Join(kept, "\n")
+	if opts.KeepForeignKeys {
+		stmt = unqualifiedRefRE.ReplaceAllString(stmt, "REFERENCES `"+strings.ReplaceAll(sampleSchema, "$", "$$")+"`.${1} (")
+		stmt = rewriteSchemaRefs(stmt, targetSchema, sampleSchema)
+	}
+	return stmt
+}
+
+// whether the foreign key definition is kept, foreign keys to other schemas are kept as they are
+func keepForeignKey(def, targetSchema string, tables map[string]struct{}, opts CopyOptions) bool {
+	if !opts.KeepForeignKeys {
+		return false
+	}
+	ref := referencedTableRE.FindStringSubmatch(def)
+	if ref == nil || (ref[1] != "" && ref[1] != targetSchema) {
+		return true
+	}
+	_, ok := tables[ref[2]]
+	return ok
+}
@@ -1,18 +1,31 @@
-package main
+package sampledb
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"database/sql"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
-	"github.com/jmoiron/sqlx"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
 )
 
 var (
@@ -39,7 +52,7 @@ func TestMain(m *testing.M) {
 }
 
 func TestCopySchema(t *testing.T) {
-	db, err := connectDB("mysql", DATABASE_HOST, DATABASE_PORT, DATABASE_USER, DATABASE_PASS)
+	db, err := Connect("mysql", DATABASE_HOST, DATABASE_PORT, DATABASE_USER, DATABASE_PASS)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -70,7 +83,7 @@ func TestCopySchema(t *testing.T) {
 		}
 	}()
 
-	err = copySchema(context.Background(), db, "copyschema", sampleSchemaName, map[string]struct{}{})
+	err = CopySchema(context.Background(), db, "copyschema", sampleSchemaName, map[string]struct{}{}, IfExistsFail)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -109,8 +122,127 @@ func TestCopySchema(t *testing.T) {
 	}
 }
 
+func TestCopyViews(t *testing.T) {
+	db, err := Connect("mysql", DATABASE_HOST, DATABASE_PORT, DATABASE_USER, DATABASE_PASS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testData, err := ioutil.ReadFile(filepath.Join("test-fixtures", "views.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(string(testData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targetSchema, sampleSchemaName := "views", fmt.Sprintf("test_views_%d", time.Now().Unix())
+	err = CopySchema(context.TODO(), db, targetSchema, sampleSchemaName, map[string]struct{}{}, IfExistsFail)
+	defer func() {
+		_, err := db.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s;", sampleSchemaName))
+		if err != nil {
+			log.Printf("err during clean up: %s\n", err)
+		}
+		testData, err := ioutil.ReadFile(filepath.Join("test-fixtures", "views_down.sql"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec(string(testData))
+		if err != nil {
+			log.Printf("err during clean up: %s\n", err)
+		}
+	}()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// views read from the sample schema tables, including the ones going through another view
+	_, err = db.Exec(fmt.Sprintf("INSERT INTO %s.employees VALUES (10001, 'Georgi', 'Facello');", sampleSchemaName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names int
+	err = db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s.active_names;", sampleSchemaName)).Scan(&names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names != 1 {
+		t.Fatalf("expected 1 row in active_names, got %d", names)
+	}
+
+	// leaving employees out leaves out the view selecting from it and the view selecting from that view
+	excludedSchema := sampleSchemaName + "_excluded"
+	defer db.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS `%s`;", excludedSchema))
+	err = CopySchemaWithOptions(context.TODO(), db, targetSchema, excludedSchema, map[string]struct{}{}, IfExistsFail,
+		CopyOptions{Exclude: []string{"employees"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables, err := showFullTables(context.TODO(), db, excludedSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tables) != 0 {
+		t.Fatalf("expected no tables or views, got %v", tables)
+	}
+}
+
+func TestSortViews(t *testing.T) {
+	order, err := sortByDependencies("views", map[string][]string{
+		"active_names":   {"employee_names"},
+		"employee_names": {},
+		"dept_summary":   {"active_names", "employee_names"},
+		"titles":         {"missing_view"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"employee_names", "active_names", "dept_summary", "titles"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Fatalf("expected %v, got %v", expected, order)
+	}
+
+	_, err = sortByDependencies("views", map[string][]string{"a": {"b"}, "b": {"a"}, "c": {}})
+	if err == nil {
+		t.Fatal("expected an error for views depending on each other")
+	}
+}
+
+func TestSkippedViews(t *testing.T) {
+	skipped := skippedViews([]string{"active_names", "employee_names", "dept_summary", "titles"}, map[string][]string{
+		"active_names":   {"employee_names"},
+		"employee_names": {"employees"},
+		"dept_summary":   {"departments", "active_names"},
+		"titles":         {"titles_raw"},
+	}, map[string]struct{}{"employees": {}})
+	expected := map[string]string{"employee_names": "employees", "active_names": "employee_names", "dept_summary": "active_names"}
+	if !reflect.DeepEqual(skipped, expected) {
+		t.Fatalf("expected %v, got %v", expected, skipped)
+	}
+}
+
+func TestViewReferences(t *testing.T) {
+	for _, c := range []struct {
+		stmt     string
+		expected []string
+	}{
+		// a column alias named like a view isn't a reference
+		{"CREATE ALGORITHM=UNDEFINED DEFINER=`root`@`%` SQL SECURITY DEFINER VIEW `active_names` AS " +
+			"select `employee_names`.`first_name` AS `dept_summary` from `shop`.`employee_names` " +
+			"where (`employee_names`.`last_name` <> 'from titles')", []string{"employee_names"}},
+		{"CREATE VIEW `dept_summary` AS select `a`.`first_name` AS `first_name` from (`active_names` `a` " +
+			"join `other`.`titles` `t` on((`a`.`emp_no` = `t`.`emp_no`))) JOIN shop.employee_names e", []string{"active_names", "employee_names"}},
+		{"CREATE VIEW `it's` AS select 1 AS `x` from (select `id` from `orders`) `o`", []string{"orders"}},
+	} {
+		refs := viewReferences(c.stmt, "shop")
+		if !reflect.DeepEqual(refs, c.expected) {
+			t.Errorf("%s: expected %v, got %v", c.stmt, c.expected, refs)
+		}
+	}
+}
+
 func TestGetFowardRelationships(t *testing.T) {
-	db, err := connectDB("mysql", DATABASE_HOST, DATABASE_PORT, DATABASE_USER, DATABASE_PASS)
+	db, err := Connect("mysql", DATABASE_HOST, DATABASE_PORT, DATABASE_USER, DATABASE_PASS)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -158,9 +290,9 @@ func TestGetFowardRelationships(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		expected := []foreignKeyConstraint{}
+		expected := []ForeignKey{}
 		for _, rel := range tableRels.Rels {
-			expected = append(expected, foreignKeyConstraint{referencedTable: rel.RefTable, referencedTableCol: rel.RefCol, tableCol: rel.Column, table: tableRels.Table})
+			expected = append(expected, ForeignKey{ReferencedTable: rel.RefTable, ReferencedColumn: rel.RefCol, Column: rel.Column, Table: tableRels.Table})
 		}
 		if !reflect.DeepEqual(expected, rels) {
 			t.FailNow()
@@ -170,7 +302,7 @@ func TestGetFowardRelationships(t *testing.T) {
 }
 
 func TestGetReverseRelationships(t *testing.T) {
-	db, err := connectDB("mysql", DATABASE_HOST, DATABASE_PORT, DATABASE_USER, DATABASE_PASS)
+	db, err := Connect("mysql", DATABASE_HOST, DATABASE_PORT, DATABASE_USER, DATABASE_PASS)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -219,9 +351,9 @@ func TestGetReverseRelationships(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		expected := []foreignKeyConstraint{}
+		expected := []ForeignKey{}
 		for _, rel := range tableRels.Rels {
-			expected = append(expected, foreignKeyConstraint{referencedTable: tableRels.Table, referencedTableCol: rel.RefCol, tableCol: rel.Column, table: rel.Table})
+			expected = append(expected, ForeignKey{ReferencedTable: tableRels.Table, ReferencedColumn: rel.RefCol, Column: rel.Column, Table: rel.Table})
 		}
 		if !reflect.DeepEqual(expected, rels) {
 			t.FailNow()
@@ -230,7 +362,7 @@ func TestGetReverseRelationships(t *testing.T) {
 }
 
 func TestInsertRowFowardRels(t *testing.T) {
-	db, err := connectDB("mysql", DATABASE_HOST, DATABASE_PORT, DATABASE_USER, DATABASE_PASS)
+	db, err := Connect("mysql", DATABASE_HOST, DATABASE_PORT, DATABASE_USER, DATABASE_PASS)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -244,7 +376,7 @@ func TestInsertRowFowardRels(t *testing.T) {
 		t.Fatal(err)
 	}
 	sampleSchemaName := "insert_foward_test"
-	err = copySchema(context.Background(), db, "insert_foward", sampleSchemaName, map[string]struct{}{})
+	err = CopySchema(context.Background(), db, "insert_foward", sampleSchemaName, map[string]struct{}{}, IfExistsFail)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -287,7 +419,8 @@ func TestInsertRowFowardRels(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	dbx := sqlx.NewDb(db, "mysql")
+	smplr := newSampler(db, "insert_foward", sampleSchemaName, 1)
+	dbx := smplr.db
 	for _, tableRels := range dts {
 		rels, err := fowardRelationships(context.TODO(), db, "insert_foward", tableRels.Table)
 		if err != nil {
@@ -307,7 +440,7 @@ func TestInsertRowFowardRels(t *testing.T) {
 		for row.Next() {
 			data := make(map[string]interface{})
 			row.MapScan(data)
-			err = insertRowFowardRels(context.TODO(), dbx, "insert_foward", sampleSchemaName, rels, data)
+			err = smplr.insertFowardRels(context.TODO(), rels, []map[string]interface{}{data})
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -327,7 +460,7 @@ func TestInsertRowFowardRels(t *testing.T) {
 }
 
 func TestSample(t *testing.T) {
-	db, err := connectDB("mysql", DATABASE_HOST, DATABASE_PORT, DATABASE_USER, DATABASE_PASS)
+	db, err := Connect("mysql", DATABASE_HOST, DATABASE_PORT, DATABASE_USER, DATABASE_PASS)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -343,7 +476,7 @@ func TestSample(t *testing.T) {
 	}
 
 	targetSchema, sampleSchemaName := "sample", fmt.Sprintf("test_sample_schema_%d", time.Now().Unix())
-	err = copySchema(context.TODO(), db, targetSchema, sampleSchemaName, map[string]struct{}{})
+	err = CopySchema(context.TODO(), db, targetSchema, sampleSchemaName, map[string]struct{}{}, IfExistsFail)
 	defer func() {
 		_, err := db.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s;", sampleSchemaName))
 		if err != nil {
@@ -392,27 +525,23 @@ func TestSample(t *testing.T) {
 		}
 
 		params := &sampleParams{table: data.Sampled, column: data.Column, data: paramData}
-		err = sample(context.TODO(), db, targetSchema, sampleSchemaName, params)
+		err = newSampler(db, targetSchema, sampleSchemaName, 1).sample(context.TODO(), params)
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		rows, err := db.Query(makeSampleQuery(sampleSchemaName, params))
+		// the anchor rows are in the sample
+		vals, err := newMysqlWriter(db, sampleSchemaName).ReadColumns(context.TODO(), data.Sampled, []string{data.Column})
 		if err != nil {
 			t.Fatal(err)
 		}
-		defer rows.Close()
-		if !rows.Next() {
-			t.FailNow()
+		sampled := map[string]struct{}{}
+		for _, val := range vals {
+			sampled[keyOf([]string{data.Column}, val)] = struct{}{}
 		}
-		for rows.Next() {
-			var pk string
-			err = rows.Scan(&pk)
-			if err != nil {
-				t.Fatal(err)
-			}
-			if pk != params.data[0] {
-				t.FailNow()
+		for _, d := range paramData {
+			if _, ok := sampled[keyOf([]string{data.Column}, []interface{}{d})]; !ok {
+				t.Fatalf("expected %s.%s=%v to be sampled", data.Sampled, data.Column, d)
 			}
 		}
 
@@ -436,7 +565,7 @@ func TestSample(t *testing.T) {
 			}
 			for rowData.Next() {
 				var count int
-				err = rows.Scan(&count)
+				err = rowData.Scan(&count)
 				if err != nil {
 					t.Fatal(err)
 				}
@@ -447,3 +576,1669 @@ func TestSample(t *testing.T) {
 		}
 	}
 }
+
+func TestSampleWorkers(t *testing.T) {
+	db, err := Connect("mysql", DATABASE_HOST, DATABASE_PORT, DATABASE_USER, DATABASE_PASS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testData, err := ioutil.ReadFile(filepath.Join("test-fixtures", "sample.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(string(testData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targetSchema, sampleSchemaName := "sample", fmt.Sprintf("test_sample_workers_%d", time.Now().Unix())
+	err = CopySchema(context.TODO(), db, targetSchema, sampleSchemaName, map[string]struct{}{}, IfExistsFail)
+	defer func() {
+		_, err := db.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s;", sampleSchemaName))
+		if err != nil {
+			log.Printf("err during clean up: %s\n", err)
+		}
+		testData, err := ioutil.ReadFile(filepath.Join("test-fixtures", "sample_down.sql"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec(string(testData))
+		if err != nil {
+			log.Printf("err during clean up: %s\n", err)
+		}
+	}()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := &sampleParams{table: "departments", column: "dept_no", data: []interface{}{"d001", "d002"}}
+	err = newSampler(db, targetSchema, sampleSchemaName, 4).sample(context.TODO(), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every department references all the employees through dept_emp
+	for table, expected := range map[string]int{"departments": 2, "dept_emp": 3, "employees": 3} {
+		var count int
+		err = db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s.%s;", sampleSchemaName, table)).Scan(&count)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != expected {
+			t.Fatalf("expected %d rows in %s, got %d", expected, table, count)
+		}
+	}
+}
+
+func TestCheckpointResume(t *testing.T) {
+	db, err := Connect("mysql", DATABASE_HOST, DATABASE_PORT, DATABASE_USER, DATABASE_PASS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testData, err := ioutil.ReadFile(filepath.Join("test-fixtures", "sample.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(string(testData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targetSchema, sampleSchemaName := "sample", fmt.Sprintf("test_sample_checkpoint_%d", time.Now().Unix())
+	err = CopySchema(context.TODO(), db, targetSchema, sampleSchemaName, map[string]struct{}{}, IfExistsFail)
+	defer func() {
+		_, err := db.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s;", sampleSchemaName))
+		if err != nil {
+			log.Printf("err during clean up: %s\n", err)
+		}
+		testData, err := ioutil.ReadFile(filepath.Join("test-fixtures", "sample_down.sql"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec(string(testData))
+		if err != nil {
+			log.Printf("err during clean up: %s\n", err)
+		}
+	}()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "sampledb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	checkpointPath := filepath.Join(dir, "checkpoint.json")
+
+	// sample a first anchor and save the progress as if we had been interrupted right after it
+	interrupted := newSampler(db, targetSchema, sampleSchemaName, 1)
+	interrupted.checkpointPath = checkpointPath
+	interrupted.anchor = &sampleParams{table: "employees", column: "emp_no", data: []interface{}{"10001", "10002"}}
+	err = interrupted.sample(context.TODO(), &sampleParams{table: "employees", column: "emp_no", data: []interface{}{"10001"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = interrupted.checkpoint(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cp, err := readCheckpoint(checkpointPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.SampleSchema != sampleSchemaName || len(cp.Anchor.Keys) != 2 {
+		t.Fatalf("unexpected checkpoint %+v", cp)
+	}
+	if !reflect.DeepEqual(cp.SampleDone["employees"], []string{"emp_no=10001"}) {
+		t.Fatalf("unexpected sampled rows %v", cp.SampleDone)
+	}
+
+	resumed := newSampler(db, cp.TargetSchema, cp.SampleSchema, 1)
+	resumed.checkpointPath = checkpointPath
+	params := resumed.restore(cp)
+	if resumed.sampleVisit.add("employees", "emp_no=10001") {
+		t.Fatal("done rows should be visited on resume")
+	}
+	err = resumed.run(context.TODO(), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(checkpointPath); !os.IsNotExist(err) {
+		t.Fatal("checkpoint should be removed after a complete run")
+	}
+	var count int
+	err = db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s.employees;", sampleSchemaName)).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("expected 2 employees, got %d", count)
+	}
+}
+
+func TestSampleAppend(t *testing.T) {
+	db, err := Connect("mysql", DATABASE_HOST, DATABASE_PORT, DATABASE_USER, DATABASE_PASS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testData, err := ioutil.ReadFile(filepath.Join("test-fixtures", "sample.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(string(testData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targetSchema, sampleSchemaName := "sample", fmt.Sprintf("test_sample_append_%d", time.Now().Unix())
+	err = CopySchema(context.TODO(), db, targetSchema, sampleSchemaName, map[string]struct{}{}, IfExistsFail)
+	defer func() {
+		_, err := db.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s;", sampleSchemaName))
+		if err != nil {
+			log.Printf("err during clean up: %s\n", err)
+		}
+		testData, err := ioutil.ReadFile(filepath.Join("test-fixtures", "sample_down.sql"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec(string(testData))
+		if err != nil {
+			log.Printf("err during clean up: %s\n", err)
+		}
+	}()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = newSampler(db, targetSchema, sampleSchemaName, 1).sample(context.TODO(), &sampleParams{table: "employees", column: "emp_no", data: []interface{}{"10001"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the sample schema exists already, we only add to it. its triggers mustn't fire on the added rows
+	_, err = db.Exec(fmt.Sprintf("CREATE TRIGGER %s.hire AFTER INSERT ON %s.employees FOR EACH ROW INSERT INTO %s.departments VALUES ('d009', 'Hires');",
+		sampleSchemaName, sampleSchemaName, sampleSchemaName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = CopySchema(context.TODO(), db, targetSchema, sampleSchemaName, map[string]struct{}{}, IfExistsReuse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// unknown modes fail before the schema is created
+	err = CopySchema(context.TODO(), db, targetSchema, sampleSchemaName+"_bogus", map[string]struct{}{}, "bogus")
+	if err == nil {
+		db.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s_bogus;", sampleSchemaName))
+		t.Fatal("expected an unknown if exists mode to fail")
+	}
+	triggers, err := db.Query(fmt.Sprintf("SHOW TRIGGERS FROM %s;", sampleSchemaName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hasTriggers := triggers.Next()
+	triggers.Close()
+	if hasTriggers {
+		t.Fatal("expected the triggers of the reused schema to be dropped")
+	}
+	smplr := newSampler(db, targetSchema, sampleSchemaName, 1)
+	err = smplr.seedVisits(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if smplr.fowardVisit.add("departments", "dept_no=d001") {
+		t.Fatal("rows in the sample should be visited")
+	}
+	// without the manifest of the previous run we don't know which rows it sampled, rows copied because
+	// they were referenced can still be sampled
+	if sampled := smplr.sampleVisit.snapshot(); len(sampled) != 0 {
+		t.Fatalf("rows in the sample shouldn't be marked sampled, got %v", sampled)
+	}
+	err = smplr.sample(context.TODO(), &sampleParams{table: "employees", column: "emp_no", data: []interface{}{"10002"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for table, expected := range map[string]int{"departments": 1, "dept_emp": 2, "employees": 2} {
+		var count int
+		err = db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s.%s;", sampleSchemaName, table)).Scan(&count)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != expected {
+			t.Fatalf("expected %d rows in %s, got %d", expected, table, count)
+		}
+	}
+}
+
+func TestCopySchemaIfExists(t *testing.T) {
+	db, err := Connect("mysql", DATABASE_HOST, DATABASE_PORT, DATABASE_USER, DATABASE_PASS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testData, err := ioutil.ReadFile(filepath.Join("test-fixtures", "refresh.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(string(testData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targetSchema, sampleSchemaName := "refresh", fmt.Sprintf("test_if_exists_%d", time.Now().Unix())
+	noSample := map[string]struct{}{"departments": {}}
+	err = CopySchema(context.TODO(), db, targetSchema, sampleSchemaName, noSample, IfExistsFail)
+	defer func() {
+		_, err := db.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s;", sampleSchemaName))
+		if err != nil {
+			log.Printf("err during clean up: %s\n", err)
+		}
+		testData, err := ioutil.ReadFile(filepath.Join("test-fixtures", "refresh_down.sql"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec(string(testData))
+		if err != nil {
+			log.Printf("err during clean up: %s\n", err)
+		}
+	}()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectRows := func(mode string, expected map[string]int) {
+		for table, n := range expected {
+			var count int
+			err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s.%s;", sampleSchemaName, table)).Scan(&count)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != n {
+				t.Fatalf("%s: expected %d rows in %s, got %d", mode, n, table, count)
+			}
+		}
+	}
+	err = newSampler(db, targetSchema, sampleSchemaName, 1).sample(context.TODO(), &sampleParams{table: "employees", column: "emp_no", data: []interface{}{"10001"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = CopySchema(context.TODO(), db, targetSchema, sampleSchemaName, noSample, IfExistsFail)
+	if err == nil {
+		t.Fatal("expected an error for an existing sample schema")
+	}
+	err = CopySchema(context.TODO(), db, targetSchema, sampleSchemaName, noSample, IfExistsReuse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectRows(IfExistsReuse, map[string]int{"departments": 2, "employees": 1})
+
+	// tables copied in full are copied again
+	err = CopySchema(context.TODO(), db, targetSchema, sampleSchemaName, noSample, IfExistsTruncate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectRows(IfExistsTruncate, map[string]int{"departments": 2, "employees": 0})
+
+	_, err = db.Exec(fmt.Sprintf("CREATE TABLE %s.leftover (id INT NOT NULL, PRIMARY KEY (id));", sampleSchemaName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = CopySchema(context.TODO(), db, targetSchema, sampleSchemaName, noSample, IfExistsDrop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables, err := showFullTables(context.TODO(), db, sampleSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := tables["leftover"]; exists {
+		t.Fatal("expected the sample schema to be dropped")
+	}
+	expectRows(IfExistsDrop, map[string]int{"departments": 2, "employees": 0})
+}
+
+func TestCopyTables(t *testing.T) {
+	db, err := Connect("mysql", DATABASE_HOST, DATABASE_PORT, DATABASE_USER, DATABASE_PASS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testData, err := ioutil.ReadFile(filepath.Join("test-fixtures", "sample.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(string(testData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("CREATE TABLE sample.notes (body VARCHAR(20)); INSERT INTO sample.notes VALUES ('a'), ('b'), ('c');")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targetSchema, sampleSchemaName := "sample", fmt.Sprintf("test_copy_tables_%d", time.Now().Unix())
+	defer func() {
+		_, err := db.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s;", sampleSchemaName))
+		if err != nil {
+			log.Printf("err during clean up: %s\n", err)
+		}
+		testData, err := ioutil.ReadFile(filepath.Join("test-fixtures", "sample_down.sql"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec(string(testData))
+		if err != nil {
+			log.Printf("err during clean up: %s\n", err)
+		}
+	}()
+	fullCopy := map[string]struct{}{"employees": {}, "dept_emp": {}, "notes": {}}
+	progress := map[string]int64{}
+	// chunks of 2 rows split the 3 rows of the single and composite key tables, the notes have no key
+	opts := CopyOptions{ChunkRows: 2, Workers: 3, Progress: func(table string, copied, estimated int64) {
+		progress[table] = copied
+	}}
+	err = CopySchemaWithOptions(context.TODO(), db, targetSchema, sampleSchemaName, fullCopy, IfExistsFail, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for table, expected := range map[string]int64{"employees": 3, "dept_emp": 3, "notes": 3, "departments": 0} {
+		var count int64
+		err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s.%s;", sampleSchemaName, table)).Scan(&count)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != expected || progress[table] != expected {
+			t.Fatalf("expected %d rows in %s, got %d and %d reported", expected, table, count, progress[table])
+		}
+	}
+
+	// filtered tables copy the rows matching their predicate, up to their limit
+	opts.Filters = map[string]TableFilter{
+		"employees": {Where: "gender = 'M'"},
+		"dept_emp":  {Where: "dept_no = 'd001'", Limit: 1},
+		"notes":     {Limit: 1},
+	}
+	progress = map[string]int64{}
+	err = CopySchemaWithOptions(context.TODO(), db, targetSchema, sampleSchemaName, fullCopy, IfExistsTruncate, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for table, expected := range map[string]string{"employees": "10001,10003", "dept_emp": "10001", "notes": ""} {
+		if table == "notes" {
+			var count int64
+			err = db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s.notes;", sampleSchemaName)).Scan(&count)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != 1 || progress["notes"] != 1 {
+				t.Fatalf("expected 1 note, got %d", count)
+			}
+			continue
+		}
+		var keys string
+		err = db.QueryRow(fmt.Sprintf("SELECT GROUP_CONCAT(emp_no ORDER BY emp_no) FROM %s.%s;", sampleSchemaName, table)).Scan(&keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if keys != expected {
+			t.Fatalf("expected %s in %s, got %s", expected, table, keys)
+		}
+	}
+
+	var chunks []copyChunk
+	err = splitTable(context.TODO(), db, targetSchema, "dept_emp", 2, TableFilter{}, func(chunk copyChunk) {
+		chunks = append(chunks, chunk)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 2 || chunks[0].lower != nil || fmt.Sprintf("%s", chunks[0].upper) != "[10002 d001]" || chunks[1].upper != nil {
+		t.Fatalf("unexpected chunks %v", chunks)
+	}
+	// the limit ends the last chunk on its last row
+	chunks = nil
+	err = splitTable(context.TODO(), db, targetSchema, "employees", 2, TableFilter{Limit: 3}, func(chunk copyChunk) {
+		chunks = append(chunks, chunk)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 2 || keyOf([]string{"emp_no"}, chunks[1].upper) != "emp_no=10003" {
+		t.Fatalf("unexpected chunks %v", chunks)
+	}
+
+	// a schema left half created by a failed copy is dropped
+	failed := sampleSchemaName + "_failed"
+	opts = CopyOptions{Filters: map[string]TableFilter{"employees": {Where: "no_such_column = 1"}}}
+	err = CopySchemaWithOptions(context.TODO(), db, targetSchema, failed, fullCopy, IfExistsFail, opts)
+	if err == nil {
+		t.Fatal("expected a bad filter to fail the copy")
+	}
+	exists, err := schemaExists(context.TODO(), db, failed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		db.Exec(fmt.Sprintf("DROP DATABASE %s;", failed))
+		t.Fatalf("expected %s to be dropped", failed)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	db, err := Connect("mysql", DATABASE_HOST, DATABASE_PORT, DATABASE_USER, DATABASE_PASS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testData, err := ioutil.ReadFile(filepath.Join("test-fixtures", "refresh.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(string(testData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targetSchema, sampleSchemaName := "refresh", fmt.Sprintf("test_refresh_%d", time.Now().Unix())
+	err = CopySchema(context.TODO(), db, targetSchema, sampleSchemaName, map[string]struct{}{}, IfExistsFail)
+	defer func() {
+		_, err := db.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s;", sampleSchemaName))
+		if err != nil {
+			log.Printf("err during clean up: %s\n", err)
+		}
+		testData, err := ioutil.ReadFile(filepath.Join("test-fixtures", "refresh_down.sql"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec(string(testData))
+		if err != nil {
+			log.Printf("err during clean up: %s\n", err)
+		}
+	}()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = newSampler(db, targetSchema, sampleSchemaName, 1).sample(context.TODO(), &sampleParams{table: "employees", column: "emp_no", data: []interface{}{"10001", "10002"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 10001 moves to a department we haven't sampled and 10002 leaves
+	for _, q := range []string{
+		"UPDATE refresh.employees SET first_name = 'Georg', dept_no = 'd002' WHERE emp_no = 10001;",
+		"DELETE FROM refresh.employees WHERE emp_no = 10002;",
+	} {
+		_, err = db.Exec(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = Refresh(context.TODO(), db, targetSchema, sampleSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var firstName, deptNo string
+	err = db.QueryRow(fmt.Sprintf("SELECT first_name, dept_no FROM %s.employees WHERE emp_no = 10001;", sampleSchemaName)).Scan(&firstName, &deptNo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if firstName != "Georg" || deptNo != "d002" {
+		t.Fatalf("row was not updated: %s %s", firstName, deptNo)
+	}
+	for table, expected := range map[string]int{"departments": 2, "employees": 1} {
+		var count int
+		err = db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s.%s;", sampleSchemaName, table)).Scan(&count)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != expected {
+			t.Fatalf("expected %d rows in %s, got %d", expected, table, count)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	db, err := Connect("mysql", DATABASE_HOST, DATABASE_PORT, DATABASE_USER, DATABASE_PASS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testData, err := ioutil.ReadFile(filepath.Join("test-fixtures", "refresh.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(string(testData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targetSchema, sampleSchemaName := "refresh", fmt.Sprintf("test_verify_%d", time.Now().Unix())
+	err = CopySchema(context.TODO(), db, targetSchema, sampleSchemaName, map[string]struct{}{}, IfExistsFail)
+	defer func() {
+		_, err := db.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s;", sampleSchemaName))
+		if err != nil {
+			log.Printf("err during clean up: %s\n", err)
+		}
+		testData, err := ioutil.ReadFile(filepath.Join("test-fixtures", "refresh_down.sql"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec(string(testData))
+		if err != nil {
+			log.Printf("err during clean up: %s\n", err)
+		}
+	}()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an employee without its department
+	_, err = db.Exec(fmt.Sprintf("INSERT INTO %s.employees SELECT * FROM refresh.employees WHERE emp_no = 10003;", sampleSchemaName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	orphans, err := Verify(context.TODO(), db, targetSchema, sampleSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Orphan{{Table: "employees", Column: "dept_no", ReferencedTable: "departments", ReferencedColumn: "dept_no", Value: "d002"}}
+	if !reflect.DeepEqual(expected, orphans) {
+		t.Fatalf("expected %v, got %v", expected, orphans)
+	}
+
+	err = newSampler(db, targetSchema, sampleSchemaName, 1).sample(context.TODO(), &sampleParams{table: "employees", column: "emp_no", data: []interface{}{"10003"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	orphans, err = Verify(context.TODO(), db, targetSchema, sampleSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 0 {
+		t.Fatalf("expected no orphans, got %v", orphans)
+	}
+
+	// the columns of a virtual composite key reference a row together, not one each
+	_, err = db.Exec(fmt.Sprintf("CREATE TABLE %s.badges (emp_no INT NOT NULL, dept_no CHAR(4) NOT NULL, PRIMARY KEY (emp_no)); ", sampleSchemaName) +
+		fmt.Sprintf("INSERT INTO %s.badges VALUES (10003, 'd002'), (10001, 'd002');", sampleSchemaName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel, err := ParseRelationship("badges.emp_no,dept_no=employees.emp_no,dept_no")
+	if err != nil {
+		t.Fatal(err)
+	}
+	orphans, err = Verify(context.TODO(), db, targetSchema, sampleSchemaName, rel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []Orphan{{Table: "badges", Column: "emp_no,dept_no", ReferencedTable: "employees", ReferencedColumn: "emp_no,dept_no", Value: "10001,d002"}}
+	if !reflect.DeepEqual(expected, orphans) {
+		t.Fatalf("expected %v, got %v", expected, orphans)
+	}
+}
+
+func TestParseRelationship(t *testing.T) {
+	rel, err := ParseRelationship("orders.shop_id,customer_id=customers.shop_id,id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Relationship{Table: "orders", Columns: []string{"shop_id", "customer_id"}, ReferencedTable: "customers", ReferencedColumns: []string{"shop_id", "id"}}
+	if !reflect.DeepEqual(expected, rel) {
+		t.Fatalf("expected %+v, got %+v", expected, rel)
+	}
+	for _, bad := range []string{"orders.customer_id", "orders=customers.id", "orders.a,b=customers.id", ".a=b.c"} {
+		if _, err := ParseRelationship(bad); err == nil {
+			t.Fatalf("expected %q to fail", bad)
+		}
+	}
+}
+
+func TestSampleReport(t *testing.T) {
+	db, err := Connect("mysql", DATABASE_HOST, DATABASE_PORT, DATABASE_USER, DATABASE_PASS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testData, err := ioutil.ReadFile(filepath.Join("test-fixtures", "sample.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(string(testData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targetSchema, sampleSchemaName := "sample", fmt.Sprintf("test_sample_report_%d", time.Now().Unix())
+	err = CopySchema(context.TODO(), db, targetSchema, sampleSchemaName, map[string]struct{}{}, IfExistsFail)
+	defer func() {
+		_, err := db.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s;", sampleSchemaName))
+		if err != nil {
+			log.Printf("err during clean up: %s\n", err)
+		}
+		testData, err := ioutil.ReadFile(filepath.Join("test-fixtures", "sample_down.sql"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec(string(testData))
+		if err != nil {
+			log.Printf("err during clean up: %s\n", err)
+		}
+	}()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	smplr := newSampler(db, targetSchema, sampleSchemaName, 1)
+	err = smplr.sample(context.TODO(), &sampleParams{table: "departments", column: "dept_no", data: []interface{}{"d001"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = smplr.report.finish(context.TODO(), smplr.source, smplr.dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for table, expected := range map[string]int64{"departments": 1, "dept_emp": 2, "employees": 2} {
+		tr := smplr.report.Tables[table]
+		if tr == nil || tr.RowsCopied != expected || tr.SampleRows != expected || tr.Bytes == 0 {
+			t.Fatalf("unexpected report for %s: %+v", table, tr)
+		}
+	}
+	var reverse *EdgeReport
+	for _, e := range smplr.report.Edges {
+		if e.Direction == "reverse" && e.Table == "dept_emp" && e.ReferencedTable == "departments" {
+			reverse = e
+		}
+	}
+	if reverse == nil || reverse.Keys != 1 || reverse.Rows != 2 || reverse.FanOut != 2 {
+		t.Fatalf("unexpected reverse edge %+v", reverse)
+	}
+	if len(smplr.report.EmptyTables) != 0 {
+		t.Fatalf("unexpected empty tables %v", smplr.report.EmptyTables)
+	}
+}
+
+func TestRewriteSchemaRefs(t *testing.T) {
+	for stmt, expected := range map[string]string{
+		"INSERT INTO shop.hires VALUES (NEW.emp_no)":            "INSERT INTO `sample`.hires VALUES (NEW.emp_no)",
+		"select `shop`.`t`.`c` AS `c` from `shop`.`t`":          "select `sample`.`t`.`c` AS `c` from `sample`.`t`",
+		"SELECT * FROM shopping.t JOIN t ON t.shop = shop.x":    "SELECT * FROM shopping.t JOIN t ON t.shop = `sample`.x",
+		"SELECT * FROM other.shop.t WHERE x_shop.y = 1":         "SELECT * FROM other.shop.t WHERE x_shop.y = 1",
+		"CREATE PROCEDURE p() SELECT COUNT(*) FROM shop.orders": "CREATE PROCEDURE p() SELECT COUNT(*) FROM `sample`.orders",
+		// string literals are left as they are
+		"SELECT 'shop.orders', \"shop.x\" FROM shop.orders": "SELECT 'shop.orders', \"shop.x\" FROM `sample`.orders",
+		"SELECT 'it''s shop.x', shop.y":                     "SELECT 'it''s shop.x', `sample`.y",
+	} {
+		if rewritten := rewriteSchemaRefs(stmt, "shop", "sample"); rewritten != expected {
+			t.Errorf("expected %q, got %q", expected, rewritten)
+		}
+	}
+}
+
+func TestStripDefiner(t *testing.T) {
+	for stmt, expected := range map[string]string{
+		"CREATE DEFINER=`root`@`%` PROCEDURE `p`() SELECT 1":                   "CREATE PROCEDURE `p`() SELECT 1",
+		"CREATE DEFINER=`app`@`10.0.%` TRIGGER `t` AFTER INSERT ON `e`":        "CREATE TRIGGER `t` AFTER INSERT ON `e`",
+		"CREATE ALGORITHM=UNDEFINED DEFINER=CURRENT_USER SQL SECURITY DEFINER": "CREATE ALGORITHM=UNDEFINED SQL SECURITY DEFINER",
+		"CREATE DEFINER='root'@'localhost' FUNCTION `f`() RETURNS int":         "CREATE FUNCTION `f`() RETURNS int",
+	} {
+		if stripped := stripDefiner(stmt); stripped != expected {
+			t.Errorf("expected %q, got %q", expected, stripped)
+		}
+	}
+}
+
+func TestRewriteCreateTable(t *testing.T) {
+	stmt := "CREATE TABLE `orders` (\n" +
+		"  `id` int NOT NULL AUTO_INCREMENT,\n" +
+		"  `customer_id` int NOT NULL,\n" +
+		"  `coupon_id` int DEFAULT NULL,\n" +
+		"  PRIMARY KEY (`id`),\n" +
+		"  CONSTRAINT `orders_customer` FOREIGN KEY (`customer_id`) REFERENCES `customers` (`id`),\n" +
+		"  CONSTRAINT `orders_coupon` FOREIGN KEY (`coupon_id`) REFERENCES `shop`.`coupons` (`id`)\n" +
+		") ENGINE=InnoDB AUTO_INCREMENT=5 DEFAULT CHARSET=utf8mb4\n" +
+		"/*!50100 PARTITION BY HASH (`id`)\nPARTITIONS 4 */"
+	tables := map[string]struct{}{"orders": {}, "customers": {}, "coupons": {}}
+	for _, c := range []struct {
+		opts     CopyOptions
+		tables   map[string]struct{}
+		expected string
+	}{
+		{CopyOptions{StripPartitions: true}, tables, "CREATE TABLE `sample`.`orders` (\n" +
+			"  `id` int NOT NULL AUTO_INCREMENT,\n" +
+			"  `customer_id` int NOT NULL,\n" +
+			"  `coupon_id` int DEFAULT NULL,\n" +
+			"  PRIMARY KEY (`id`)\n" +
+			") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4"},
+		{CopyOptions{KeepAutoIncrement: true, KeepForeignKeys: true}, tables, "CREATE TABLE `sample`.`orders` (\n" +
+			"  `id` int NOT NULL AUTO_INCREMENT,\n" +
+			"  `customer_id` int NOT NULL,\n" +
+			"  `coupon_id` int DEFAULT NULL,\n" +
+			"  PRIMARY KEY (`id`),\n" +
+			"  CONSTRAINT `orders_customer` FOREIGN KEY (`customer_id`) REFERENCES `sample`.`customers` (`id`),\n" +
+			"  CONSTRAINT `orders_coupon` FOREIGN KEY (`coupon_id`) REFERENCES `sample`.`coupons` (`id`)\n" +
+			") ENGINE=InnoDB AUTO_INCREMENT=5 DEFAULT CHARSET=utf8mb4\n" +
+			"/*!50100 PARTITION BY HASH (`id`)\nPARTITIONS 4 */"},
+		// the coupons aren't in the sample schema
+		{CopyOptions{KeepForeignKeys: true}, map[string]struct{}{"orders": {}, "customers": {}}, "CREATE TABLE `sample`.`orders` (\n" +
+			"  `id` int NOT NULL AUTO_INCREMENT,\n" +
+			"  `customer_id` int NOT NULL,\n" +
+			"  `coupon_id` int DEFAULT NULL,\n" +
+			"  PRIMARY KEY (`id`),\n" +
+			"  CONSTRAINT `orders_customer` FOREIGN KEY (`customer_id`) REFERENCES `sample`.`customers` (`id`)\n" +
+			") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4\n" +
+			"/*!50100 PARTITION BY HASH (`id`)\nPARTITIONS 4 */"},
+	} {
+		if rewritten := rewriteCreateTable(stmt, "shop", "sample", c.tables, c.opts); rewritten != c.expected {
+			t.Errorf("%+v: expected %q, got %q", c.opts, c.expected, rewritten)
+		}
+	}
+}
+
+func TestCopySchemaTables(t *testing.T) {
+	db, err := Connect("mysql", DATABASE_HOST, DATABASE_PORT, DATABASE_USER, DATABASE_PASS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testData, err := ioutil.ReadFile(filepath.Join("test-fixtures", "sample.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(string(testData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targetSchema, sampleSchemaName := "sample", fmt.Sprintf("test_copy_schema_tables_%d", time.Now().Unix())
+	defer func() {
+		_, err := db.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s;", sampleSchemaName))
+		if err != nil {
+			log.Printf("err during clean up: %s\n", err)
+		}
+		testData, err := ioutil.ReadFile(filepath.Join("test-fixtures", "sample_down.sql"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec(string(testData))
+		if err != nil {
+			log.Printf("err during clean up: %s\n", err)
+		}
+	}()
+	opts := CopyOptions{Include: []string{"dep*", "employees"}, Exclude: []string{"/^departments$/"}}
+	err = CopySchemaWithOptions(context.TODO(), db, targetSchema, sampleSchemaName, map[string]struct{}{}, IfExistsFail, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables, err := showFullTables(context.TODO(), db, sampleSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(setKeys(setOf(tables)), []string{"dept_emp", "employees"}) {
+		t.Fatalf("expected dept_emp and employees to be created, got %v", tables)
+	}
+
+	// kept foreign keys reference the sample tables
+	opts = CopyOptions{KeepForeignKeys: true}
+	err = CopySchemaWithOptions(context.TODO(), db, targetSchema, sampleSchemaName, map[string]struct{}{}, IfExistsDrop, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := schemaObject{kind: "TABLE", name: "dept_emp"}
+	err = showCreate(context.TODO(), db, sampleSchemaName, &obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(obj.create, "FOREIGN KEY") || strings.Contains(obj.create, "`"+targetSchema+"`.") {
+		t.Fatalf("expected dept_emp to keep its foreign keys to the sample tables, got %s", obj.create)
+	}
+
+	err = CopySchemaWithOptions(context.TODO(), db, targetSchema, sampleSchemaName, map[string]struct{}{}, IfExistsDrop, CopyOptions{Include: []string{"missing"}})
+	if err == nil {
+		t.Fatal("expected an unknown included table to fail")
+	}
+}
+
+func TestCopyObjects(t *testing.T) {
+	db, err := Connect("mysql", DATABASE_HOST, DATABASE_PORT, DATABASE_USER, DATABASE_PASS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testData, err := ioutil.ReadFile(filepath.Join("test-fixtures", "objects.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(string(testData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targetSchema, sampleSchemaName := "objects", fmt.Sprintf("test_objects_%d", time.Now().Unix())
+	err = CopySchema(context.TODO(), db, targetSchema, sampleSchemaName, map[string]struct{}{}, IfExistsFail)
+	defer func() {
+		_, err := db.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s;", sampleSchemaName))
+		if err != nil {
+			log.Printf("err during clean up: %s\n", err)
+		}
+		testData, err := ioutil.ReadFile(filepath.Join("test-fixtures", "objects_down.sql"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec(string(testData))
+		if err != nil {
+			log.Printf("err during clean up: %s\n", err)
+		}
+	}()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var routines int
+	err = db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM information_schema.routines WHERE routine_schema = '%s';", sampleSchemaName)).Scan(&routines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if routines != 2 {
+		t.Fatalf("expected 2 routines, got %d", routines)
+	}
+
+	err = newSampler(db, targetSchema, sampleSchemaName, 1).sample(context.TODO(), &sampleParams{table: "employees", column: "emp_no", data: []interface{}{"10001"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = CopyTriggers(context.TODO(), db, targetSchema, sampleSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = CopyEvents(context.TODO(), db, targetSchema, sampleSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the trigger didn't fire while sampling and it now writes to the sample schema
+	_, err = db.Exec(fmt.Sprintf("INSERT INTO %s.employees VALUES (10003, 'Parto');", sampleSchemaName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for schema, expected := range map[string]int{sampleSchemaName: 1, targetSchema: 0} {
+		var hires int
+		err = db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s.hires;", schema)).Scan(&hires)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hires != expected {
+			t.Fatalf("expected %d hires in %s, got %d", expected, schema, hires)
+		}
+	}
+
+	// refresh drops the trigger while it changes rows and creates it again, 10003 and its hire aren't in the
+	// target schema so they're removed
+	err = Refresh(context.TODO(), db, targetSchema, sampleSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(fmt.Sprintf("INSERT INTO %s.employees VALUES (10004, 'Chirstian');", sampleSchemaName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hired int
+	err = db.QueryRow(fmt.Sprintf("SELECT emp_no FROM %s.hires;", sampleSchemaName)).Scan(&hired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hired != 10004 {
+		t.Fatalf("expected the trigger to fire after refresh, got the hire of %d", hired)
+	}
+}
+
+func TestMakeKeysQuery(t *testing.T) {
+	q, args := makeKeysQuery("sample", "dept_emp", []string{"emp_no", "dept_no"}, [][]interface{}{{10001, "d001"}, {10002, "d001"}}, []string{"emp_no", "dept_no"})
+	expected := "SELECT * FROM `sample`.`dept_emp` WHERE (`emp_no`, `dept_no`) IN ((?, ?), (?, ?)) ORDER BY `emp_no`, `dept_no`;"
+	if q != expected {
+		t.Fatalf("expected %s, got %s", expected, q)
+	}
+	if !reflect.DeepEqual(args, []interface{}{10001, "d001", 10002, "d001"}) {
+		t.Fatalf("unexpected args %v", args)
+	}
+	q, _ = makeKeysQuery("sample", "employees", []string{"emp_no"}, [][]interface{}{{10001}}, nil)
+	if q != "SELECT * FROM `sample`.`employees` WHERE `emp_no` IN (?);" {
+		t.Fatalf("unexpected query %s", q)
+	}
+}
+
+func TestGoName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"customer_id":  "CustomerID",
+		"order_items":  "OrderItems",
+		"2fa_codes":    "T2faCodes",
+		"api-key.hash": "APIKeyHash",
+	} {
+		if got := goName(name); got != expected {
+			t.Fatalf("%s: expected %s, got %s", name, expected, got)
+		}
+	}
+	names := goNames([]string{"users", "users_row", "order_items", "OrderItems"})
+	expected := map[string]string{"users": "Users", "users_row": "UsersRow2", "order_items": "OrderItems", "OrderItems": "OrderItems2"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected names %v, got %v", expected, names)
+	}
+	if pkg := goPackage("type"); pkg != "fixtures" {
+		t.Fatalf("expected keywords not to name packages, got %s", pkg)
+	}
+}
+
+func TestGoEncoder(t *testing.T) {
+	columns := []ExportedColumn{
+		{Name: "id", Type: "int", Encoding: EncodingNumber},
+		{Name: "note", Type: "varchar(20)", Encoding: EncodingText, Nullable: true},
+		{Name: "shipped_at", Type: "datetime", Encoding: EncodingDatetime, Nullable: true},
+		{Name: "tracking_no", Type: "bigint(20) unsigned", Encoding: EncodingNumber, Nullable: true},
+	}
+	// the time package is only imported when a value refers to it
+	for name, rows := range map[string][][][]byte{
+		"empty":    nil,
+		"all null": {{[]byte("1"), []byte("sometime. maybe"), nil, nil}},
+		"dated":    {{[]byte("2"), nil, []byte("2020-01-02 10:11:12"), []byte("18446744073709551615")}},
+	} {
+		out := &bytes.Buffer{}
+		enc := newGoEncoder(out, "fixtures", "orders", "Orders", columns)
+		for _, vals := range rows {
+			err := enc.writeRow(vals)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := enc.flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+		typeCheckGo(t, name, out.Bytes())
+	}
+}
+
+// fails the test unless the source compiles
+func typeCheckGo(t *testing.T, name string, src []byte) {
+	t.Helper()
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, name+".go", src, 0)
+	if err != nil {
+		t.Fatalf("%s: %s\n%s", name, err, src)
+	}
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	_, err = conf.Check("fixtures", fset, []*ast.File{f}, nil)
+	if err != nil {
+		t.Fatalf("%s: %s\n%s", name, err, src)
+	}
+}
+
+func TestParseAnchor(t *testing.T) {
+	for anchor, expected := range map[string]Anchor{
+		"employees":                    {Table: "employees"},
+		"employees#emp_no=10001,10002": {Table: "employees", Column: "emp_no", Values: []string{"10001", "10002"}},
+	} {
+		a, err := ParseAnchor(anchor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(expected, a) {
+			t.Fatalf("%s: expected %+v, got %+v", anchor, expected, a)
+		}
+	}
+	_, err := ParseAnchor("employees#")
+	if err == nil {
+		t.Fatal("expected an error for a bad anchor")
+	}
+}
+
+func TestConnConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sampledb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	optionFile := filepath.Join(dir, "my.cnf")
+	err = ioutil.WriteFile(optionFile, []byte(`
+[mysqld]
+port = 3307
+
+[client]
+user = reader
+password = "p#ss w0rd"
+host = db.internal # primary
+ssl-mode = REQUIRED
+
+[sampledb]
+connect_timeout = 5
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		cfg      ConnConfig
+		expected string
+	}{
+		{ConnConfig{}, "root:root@tcp(localhost:3306)/?multiStatements=true"},
+		{ConnConfig{Host: "db", User: "u", Pass: "p"}, "u:p@tcp(db:3306)/?multiStatements=true"},
+		{ConnConfig{OptionFile: optionFile}, "reader:p#ss w0rd@tcp(db.internal:3306)/?multiStatements=true&timeout=5s&tls=skip-verify"},
+		{ConnConfig{OptionFile: optionFile, DSN: "writer@tcp(primary:3310)/?tls=true&readTimeout=1m"},
+			"writer:p#ss w0rd@tcp(primary:3310)/?multiStatements=true&readTimeout=1m&timeout=5s&tls=true"},
+		{ConnConfig{DSN: "u:p@tcp(primary:3310)/shop", Port: "3311", Params: map[string]string{"charset": "utf8mb4"}},
+			"u:p@tcp(primary:3311)/shop?multiStatements=true&charset=utf8mb4"},
+		{ConnConfig{DSN: "u:p@tcp(primary:3310)/", Host: "replica", Socket: "/tmp/mysql.sock"}, "u:p@unix(/tmp/mysql.sock)/?multiStatements=true"},
+		{ConnConfig{DSN: "u:p@tcp(primary:3310)/", Env: ConnEnv{Host: "env", User: "envuser", Pass: "envpass"}}, "u:p@tcp(primary:3310)/?multiStatements=true"},
+		{ConnConfig{OptionFile: optionFile, Env: ConnEnv{Port: "3312", User: "envuser", Pass: "", PassSet: true}},
+			"envuser@tcp(db.internal:3312)/?multiStatements=true&timeout=5s&tls=skip-verify"},
+		{ConnConfig{DSN: "u:p@tcp(primary:3310)/", Pass: "", PassSet: true}, "u@tcp(primary:3310)/?multiStatements=true"},
+	} {
+		dsn, err := c.cfg.FormatDSN()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dsn != c.expected {
+			t.Fatalf("%+v: expected %s, got %s", c.cfg, c.expected, dsn)
+		}
+	}
+	_, err = ConnConfig{Params: map[string]string{"tls": "maybe"}}.FormatDSN()
+	if err == nil {
+		t.Fatal("expected an error for an invalid tls mode")
+	}
+}
+
+func TestSampleOptions(t *testing.T) {
+	db, err := Connect("mysql", DATABASE_HOST, DATABASE_PORT, DATABASE_USER, DATABASE_PASS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testData, err := ioutil.ReadFile(filepath.Join("test-fixtures", "sample.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(string(testData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := Sample(context.TODO(), db, Options{
+		TargetSchema: "sample",
+		Anchor:       Anchor{Table: "departments", Column: "dept_no", Values: []string{"d001"}},
+		NoSample:     []string{"departments"},
+	})
+	defer func() {
+		if res != nil {
+			_, err := db.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s;", res.SampleSchema))
+			if err != nil {
+				log.Printf("err during clean up: %s\n", err)
+			}
+		}
+		testData, err := ioutil.ReadFile(filepath.Join("test-fixtures", "sample_down.sql"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec(string(testData))
+		if err != nil {
+			log.Printf("err during clean up: %s\n", err)
+		}
+	}()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(res.SampleSchema, "sample_db_") {
+		t.Fatalf("expected a generated sample schema name, got %s", res.SampleSchema)
+	}
+	for table, expected := range map[string]int64{"departments": 2, "dept_emp": 2, "employees": 2} {
+		tr := res.Report.Tables[table]
+		if tr == nil || tr.SampleRows != expected {
+			t.Fatalf("unexpected report for %s: %+v", table, tr)
+		}
+	}
+
+	// a failed run drops the sample schema it created
+	failed := fmt.Sprintf("test_sample_failed_%d", time.Now().Unix())
+	_, err = Sample(context.TODO(), db, Options{
+		TargetSchema: "sample",
+		SampleSchema: failed,
+		Anchor:       Anchor{Table: "departments", Column: "no_such_column", Values: []string{"d001"}},
+	})
+	if err == nil {
+		t.Fatal("expected an error for a missing anchor column")
+	}
+	exists, err := schemaExists(context.TODO(), db, failed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		db.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s;", failed))
+		t.Fatal("expected the sample schema of the failed run to be dropped")
+	}
+}
+
+func TestMysqlWriterFailedInsert(t *testing.T) {
+	db, err := Connect("mysql", DATABASE_HOST, DATABASE_PORT, DATABASE_USER, DATABASE_PASS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// the writer gets back the connection it used
+	db.SetMaxOpenConns(1)
+	schema := fmt.Sprintf("test_writer_%d", time.Now().Unix())
+	_, err = db.Exec(fmt.Sprintf("CREATE DATABASE %s;", schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s;", schema))
+
+	w := newMysqlWriter(db, schema)
+	_, err = w.WriteRows(context.TODO(), "missing", []map[string]interface{}{{"id": 1}})
+	if err == nil {
+		t.Fatal("expected an error inserting into a missing table")
+	}
+	var checks int
+	err = db.QueryRow("SELECT @@foreign_key_checks;").Scan(&checks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checks != 1 {
+		t.Fatalf("expected foreign key checks to be back on, got %d", checks)
+	}
+}
+
+func TestNewSamplerBudgetWorkers(t *testing.T) {
+	// which rows fit in the budget would depend on the order concurrent workers read them in
+	if s := NewSampler(nil, Options{Workers: 4, MaxRows: 5}); cap(s.workers) != 0 {
+		t.Fatalf("expected a single worker with a budget, got %d", cap(s.workers)+1)
+	}
+	if s := NewSampler(nil, Options{Workers: 4}); cap(s.workers) != 3 {
+		t.Fatalf("expected 4 workers, got %d", cap(s.workers)+1)
+	}
+}
+
+func TestExport(t *testing.T) {
+	db, err := Connect("mysql", DATABASE_HOST, DATABASE_PORT, DATABASE_USER, DATABASE_PASS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testData, err := ioutil.ReadFile(filepath.Join("test-fixtures", "export.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(string(testData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "sampledb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		os.RemoveAll(dir)
+		testData, err := ioutil.ReadFile(filepath.Join("test-fixtures", "export_down.sql"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec(string(testData))
+		if err != nil {
+			log.Printf("err during clean up: %s\n", err)
+		}
+	}()
+
+	for format, expected := range map[string]string{
+		ExportCSV: "id,customer_id,total,placed_on,placed_at,note,receipt,attrs\n" +
+			"10,1,12.50,2020-01-02,2020-01-02T10:11:12,\"gift, wrapped\",aGk=,\"{\"\"rush\"\": true}\"\n" +
+			"11,2,99999999.99,2020-02-03,2020-02-03T00:00:00,\\N,\\N,\\N\n",
+		ExportJSONL: `{"id":10,"customer_id":1,"total":"12.50","placed_on":"2020-01-02","placed_at":"2020-01-02T10:11:12","note":"gift, wrapped","receipt":"aGk=","attrs":{"rush": true}}` + "\n" +
+			`{"id":11,"customer_id":2,"total":"99999999.99","placed_on":"2020-02-03","placed_at":"2020-02-03T00:00:00","note":null,"receipt":null,"attrs":null}` + "\n",
+		ExportYAML: `- "id": 10
+  "customer_id": 1
+  "total": "12.50"
+  "placed_on": "2020-01-02"
+  "placed_at": "2020-01-02 10:11:12"
+  "note": "gift, wrapped"
+  "receipt": !!binary "aGk="
+  "attrs": "{\"rush\": true}"
+- "id": 11
+  "customer_id": 2
+  "total": "99999999.99"
+  "placed_on": "2020-02-03"
+  "placed_at": "2020-02-03 00:00:00"
+  "note": null
+  "receipt": null
+  "attrs": null
+`,
+	} {
+		out := filepath.Join(dir, format)
+		m, err := Export(context.TODO(), db, "export", "export", out, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(filepath.Join(out, m.Tables[1].File))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expected {
+			t.Fatalf("expected %s export\n%s\ngot\n%s", format, expected, data)
+		}
+		read, err := ReadManifest(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(m, read) {
+			t.Fatalf("expected manifest %+v, got %+v", m, read)
+		}
+		orders := m.Tables[1]
+		if m.Tables[0].Rows != 2 || orders.Name != "orders" || orders.Rows != 2 || orders.Columns[2].Encoding != EncodingDecimal ||
+			!reflect.DeepEqual(orders.ForeignKeys, []ExportedForeignKey{{Column: "customer_id", ReferencedTable: "customers", ReferencedColumn: "id"}}) {
+			t.Fatalf("unexpected manifest %+v", m)
+		}
+	}
+	_, err = Export(context.TODO(), db, "export", "export", filepath.Join(dir, "shop_fixtures"), ExportGo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "shop_fixtures", "orders.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	typeCheckGo(t, "orders", data)
+	for _, want := range []string{
+		"package shopfixtures\n",
+		"\tNote       sql.NullString `db:\"note\"`\n",
+		"\t{ID: 11, CustomerID: 2, Total: \"99999999.99\", PlacedOn: time.Date(2020, 2, 3, 0, 0, 0, 0, time.UTC), PlacedAt: time.Date(2020, 2, 3, 0, 0, 0, 0, time.UTC)},\n",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Fatalf("expected the generated code to contain %q, got\n%s", want, data)
+		}
+	}
+	// sample tables have no foreign keys, the manifest gets them from the target schema
+	_, err = db.Exec("CREATE DATABASE export_sample; CREATE TABLE export_sample.customers (id INT PRIMARY KEY); CREATE TABLE export_sample.orders (id INT PRIMARY KEY, customer_id INT);")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DROP DATABASE IF EXISTS export_sample;")
+	for relSchema, expected := range map[string][]ExportedForeignKey{
+		"export_sample": {},
+		"export":        {{Column: "customer_id", ReferencedTable: "customers", ReferencedColumn: "id"}},
+	} {
+		m, err := Export(context.TODO(), db, relSchema, "export_sample", filepath.Join(dir, relSchema), ExportCSV)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(m.Tables[1].ForeignKeys, expected) {
+			t.Fatalf("expected the foreign keys of %s %v, got %v", relSchema, expected, m.Tables[1].ForeignKeys)
+		}
+	}
+	// parquet files end with their metadata, its length and the magic bytes, 99999999.99 is written unscaled
+	m, err := Export(context.TODO(), db, "export", "export", filepath.Join(dir, ExportParquet), ExportParquet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = ioutil.ReadFile(filepath.Join(dir, ExportParquet, m.Tables[1].File))
+	if err != nil {
+		t.Fatal(err)
+	}
+	footer := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	if !bytes.HasPrefix(data, []byte("PAR1")) || !bytes.HasSuffix(data, []byte("PAR1")) || footer >= len(data)-12 ||
+		!bytes.Contains(data[len(data)-8-footer:], []byte("customer_id")) || !bytes.Contains(data, []byte("\x05\x00\x00\x00\x02\x54\x0b\xe3\xff")) {
+		t.Fatalf("unexpected parquet file %q", data)
+	}
+	_, err = Export(context.TODO(), db, "export", "export", dir, "xml")
+	if err == nil {
+		t.Fatal("expected unknown formats to fail")
+	}
+}
+
+func TestParquetLevels(t *testing.T) {
+	// runs of a length shifted left by one and the level
+	levels := parquetLevels([]bool{true, true, false, true})
+	if !bytes.Equal(levels, []byte{4, 1, 2, 0, 2, 1}) {
+		t.Fatalf("unexpected definition levels %v", levels)
+	}
+}
+
+func TestParquetRoundTrip(t *testing.T) {
+	columns := []ExportedColumn{
+		{Name: "id", Type: "bigint unsigned", Encoding: EncodingNumber},
+		{Name: "total", Type: "decimal(10,2)", Encoding: EncodingDecimal},
+		{Name: "placed_on", Type: "date", Encoding: EncodingDate},
+		{Name: "placed_at", Type: "datetime(6)", Encoding: EncodingDatetime},
+		{Name: "note", Type: "varchar(100)", Nullable: true, Encoding: EncodingText},
+		{Name: "receipt", Type: "blob", Nullable: true, Encoding: EncodingBase64},
+		{Name: "attrs", Type: "json", Nullable: true, Encoding: EncodingJSON},
+		{Name: "weight", Type: "double", Encoding: EncodingNumber},
+	}
+	buf := &bytes.Buffer{}
+	enc := newParquetEncoder(buf, columns)
+	for _, row := range [][][]byte{
+		{[]byte("18446744073709551615"), []byte("12.50"), []byte("2020-01-02"), []byte("2020-01-02 10:11:12.5"),
+			[]byte("gift, wrapped"), []byte("\x00\xff"), []byte(`{"rush": true}`), []byte("1.5")},
+		{[]byte("1"), []byte("-0.05"), []byte("1969-12-31"), []byte("1970-01-01 00:00:00"), nil, nil, nil, []byte("0")},
+		{[]byte("2"), []byte("99999999.99"), []byte("2020-02-03"), []byte("2020-02-03 00:00:00"), []byte(""), []byte(""), []byte("[]"), []byte("-2")},
+	} {
+		err := enc.writeRow(row)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := enc.flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := buffer.NewBufferFile(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, err := reader.NewParquetColumnReader(file, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := pr.GetNumRows(); n != 3 {
+		t.Fatalf("expected 3 rows, got %d", n)
+	}
+	schema := pr.Footer.Schema[1:]
+	for i, expected := range []struct {
+		typ       parquet.Type
+		converted parquet.ConvertedType
+		logical   func(*parquet.LogicalType) bool
+	}{
+		{parquet.Type_INT64, parquet.ConvertedType_UINT_64, func(l *parquet.LogicalType) bool {
+			return l.IsSetINTEGER() && l.INTEGER.GetBitWidth() == 64 && !l.INTEGER.GetIsSigned()
+		}},
+		{parquet.Type_BYTE_ARRAY, parquet.ConvertedType_DECIMAL, func(l *parquet.LogicalType) bool {
+			return l.IsSetDECIMAL() && l.DECIMAL.GetPrecision() == 10 && l.DECIMAL.GetScale() == 2
+		}},
+		{parquet.Type_INT32, parquet.ConvertedType_DATE, (*parquet.LogicalType).IsSetDATE},
+		{parquet.Type_INT64, parquet.ConvertedType_TIMESTAMP_MICROS, func(l *parquet.LogicalType) bool {
+			return l.IsSetTIMESTAMP() && l.TIMESTAMP.GetIsAdjustedToUTC() && l.TIMESTAMP.GetUnit().IsSetMICROS()
+		}},
+		{parquet.Type_BYTE_ARRAY, parquet.ConvertedType_UTF8, (*parquet.LogicalType).IsSetSTRING},
+		{parquet.Type_BYTE_ARRAY, -1, nil},
+		{parquet.Type_BYTE_ARRAY, parquet.ConvertedType_JSON, (*parquet.LogicalType).IsSetJSON},
+		{parquet.Type_DOUBLE, -1, nil},
+	} {
+		el := schema[i]
+		if el.GetType() != expected.typ || expected.converted == -1 && el.IsSetConvertedType() ||
+			expected.converted != -1 && el.GetConvertedType() != expected.converted {
+			t.Fatalf("unexpected type %s %s of %s", el.GetType(), el.GetConvertedType(), el.GetName())
+		}
+		if expected.logical == nil && el.IsSetLogicalType() || expected.logical != nil && !expected.logical(el.GetLogicalType()) {
+			t.Fatalf("unexpected logical type %s of %s", el.GetLogicalType(), el.GetName())
+		}
+	}
+	if schema[1].GetPrecision() != 10 || schema[1].GetScale() != 2 {
+		t.Fatalf("expected decimal(10,2), got decimal(%d,%d)", schema[1].GetPrecision(), schema[1].GetScale())
+	}
+
+	micros := func(s string) interface{} {
+		ts, _ := time.Parse("2006-01-02 15:04:05.999999", s)
+		return ts.UnixNano() / 1000
+	}
+	for i, expected := range [][]interface{}{
+		{int64(-1), int64(1), int64(2)},
+		// unscaled big-endian two's complement
+		{"\x04\xe2", "\xfb", "\x02\x54\x0b\xe3\xff"},
+		{int32(18263), int32(-1), int32(18295)},
+		{micros("2020-01-02 10:11:12.5"), int64(0), micros("2020-02-03 00:00:00")},
+		{"gift, wrapped", nil, ""},
+		{"\x00\xff", nil, ""},
+		{`{"rush": true}`, nil, "[]"},
+		{1.5, 0.0, -2.0},
+	} {
+		vals, _, _, err := pr.ReadColumnByIndex(int64(i), 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(vals, expected) {
+			t.Errorf("expected %s to read %#v, got %#v", columns[i].Name, expected, vals)
+		}
+	}
+}
+
+func TestSeededRandomRows(t *testing.T) {
+	db, err := Connect("mysql", DATABASE_HOST, DATABASE_PORT, DATABASE_USER, DATABASE_PASS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testData, err := ioutil.ReadFile(filepath.Join("test-fixtures", "export.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(string(testData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		testData, err := ioutil.ReadFile(filepath.Join("test-fixtures", "export_down.sql"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec(string(testData))
+		if err != nil {
+			log.Printf("err during clean up: %s\n", err)
+		}
+	}()
+
+	source := newMysqlSchema(db, "export")
+	picked := ""
+	for i := 0; i < 3; i++ {
+		rows, err := source.RandomRows(context.TODO(), "orders", 1, 42)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != 1 {
+			t.Fatalf("expected a row, got %v", rows)
+		}
+		id := string(rows[0]["id"].([]byte))
+		if picked != "" && id != picked {
+			t.Fatalf("expected order %s with the same seed, got %s", picked, id)
+		}
+		picked = id
+	}
+}
+
+func TestLoad(t *testing.T) {
+	db, err := Connect("mysql", DATABASE_HOST, DATABASE_PORT, DATABASE_USER, DATABASE_PASS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testData, err := ioutil.ReadFile(filepath.Join("test-fixtures", "export.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(string(testData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "sampledb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loadSchema := fmt.Sprintf("test_load_%d", time.Now().Unix())
+	defer func() {
+		os.RemoveAll(dir)
+		_, err := db.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s;", loadSchema))
+		if err != nil {
+			log.Printf("err during clean up: %s\n", err)
+		}
+		testData, err := ioutil.ReadFile(filepath.Join("test-fixtures", "export_down.sql"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec(string(testData))
+		if err != nil {
+			log.Printf("err during clean up: %s\n", err)
+		}
+	}()
+
+	query := "SELECT id, customer_id, total, placed_on, placed_at, note, receipt, attrs FROM %s.orders ORDER BY id;"
+	expected, err := queryStrings(db, fmt.Sprintf(query, "export"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, format := range []string{ExportCSV, ExportJSONL} {
+		out := filepath.Join(dir, format)
+		_, err = Export(context.TODO(), db, "export", "export", out, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := ReadManifest(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		order, err := loadOrder(m, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if order[0].Name != "customers" || order[1].Name != "orders" {
+			t.Fatalf("expected customers to load before orders, got %s, %s", order[0].Name, order[1].Name)
+		}
+
+		loaded, err := Load(context.TODO(), db, out, LoadOptions{Schema: loadSchema, IfExists: IfExistsDrop, Checks: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(loaded, map[string]int64{"customers": 2, "orders": 2}) {
+			t.Fatalf("%s: unexpected loaded rows %v", format, loaded)
+		}
+		got, err := queryStrings(db, fmt.Sprintf(query, loadSchema))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(expected, got) {
+			t.Fatalf("%s: expected rows %q, got %q", format, expected, got)
+		}
+	}
+	_, err = Load(context.TODO(), db, filepath.Join(dir, ExportCSV), LoadOptions{Schema: loadSchema})
+	if err == nil {
+		t.Fatal("expected loading into an existing schema to fail")
+	}
+	loaded, err := Load(context.TODO(), db, filepath.Join(dir, ExportCSV), LoadOptions{Schema: loadSchema, IfExists: IfExistsReuse})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded["orders"] != 0 {
+		t.Fatalf("expected rows already loaded to be skipped, loaded %v", loaded)
+	}
+}
+
+// returns the rows of the query as strings, NULL values as <nil>
+func queryStrings(db *sql.DB, query string) ([][]string, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	res := [][]string{}
+	for rows.Next() {
+		vals := make([]sql.NullString, len(cols))
+		ptrs := make([]interface{}, len(cols))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		err = rows.Scan(ptrs...)
+		if err != nil {
+			return nil, err
+		}
+		row := make([]string, len(cols))
+		for i, v := range vals {
+			row[i] = "<nil>"
+			if v.Valid {
+				row[i] = v.String
+			}
+		}
+		res = append(res, row)
+	}
+	return res, rows.Err()
+}
+
+func TestEncoderRoundTrip(t *testing.T) {
+	columns := []ExportedColumn{
+		{Name: "note", Type: "varchar(10)", Nullable: true, Encoding: EncodingText},
+		{Name: "attrs", Type: "json", Nullable: true, Encoding: EncodingJSON},
+	}
+	// text reading like the csv NULL marker, a JSON string document and a value that isn't valid JSON
+	rows := [][][]byte{
+		{[]byte(`\N`), []byte(`"\\N"`)},
+		{[]byte(`\\N`), []byte(`{bad`)},
+		{[]byte(`N`), []byte(`{"rush": true}`)},
+		{nil, nil},
+	}
+	for _, format := range []string{ExportCSV, ExportJSONL} {
+		buf := &bytes.Buffer{}
+		w := bufio.NewWriter(buf)
+		var enc tableEncoder = &jsonlEncoder{w: w, columns: columns}
+		var err error
+		if format == ExportCSV {
+			enc, err = newCSVEncoder(w, columns)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, row := range rows {
+			err = enc.writeRow(row)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err = enc.flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = w.Flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var dec tableDecoder = &jsonlDecoder{r: bufio.NewReader(buf), columns: columns}
+		if format == ExportCSV {
+			dec, err = newCSVDecoder(buf, columns)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, row := range rows {
+			loaded, err := dec.next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, val := range row {
+				if val == nil && loaded[i] != nil || val != nil && loaded[i] != string(val) {
+					t.Errorf("%s: expected %s to load %q, got %#v", format, columns[i].Name, val, loaded[i])
+				}
+			}
+		}
+		if _, err = dec.next(); err != io.EOF {
+			t.Fatalf("%s: expected the end of the rows, got %v", format, err)
+		}
+	}
+}
+
+func TestMatchTables(t *testing.T) {
+	tables := []string{"customers", "lookup_colors", "lookup_sizes", "orders", "ref_countries"}
+	matched, byPattern, err := matchTables([]string{"lookup_*", "/^(ref|cust)/", "orders"}, tables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(matched, []string{"customers", "lookup_colors", "lookup_sizes", "orders", "ref_countries"}) {
+		t.Fatalf("unexpected tables %v", matched)
+	}
+	expected := map[string][]string{
+		"lookup_*":      {"lookup_colors", "lookup_sizes"},
+		"/^(ref|cust)/": {"customers", "ref_countries"},
+		"orders":        {"orders"},
+	}
+	if !reflect.DeepEqual(byPattern, expected) {
+		t.Fatalf("expected %v, got %v", expected, byPattern)
+	}
+	// regular expressions match anywhere in the name
+	matched, _, err = matchTables([]string{"lookup_?izes", "/rd/"}, tables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(matched, []string{"lookup_sizes", "orders"}) {
+		t.Fatalf("unexpected tables %v", matched)
+	}
+	for _, patterns := range [][]string{{"order"}, {"tmp_*"}, {"/(/"}, {"lookup_["}} {
+		_, _, err = matchTables(patterns, tables)
+		if err == nil {
+			t.Fatalf("expected %v to fail", patterns)
+		}
+	}
+}
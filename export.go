@@ -0,0 +1,389 @@
+package sampledb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// export formats
+const (
+	ExportCSV   = "csv"
+	ExportJSONL = "jsonl"
+	// Go source with a struct type per table and a slice literal of its rows
+	ExportGo = "go"
+	// go-testfixtures YAML files
+	ExportYAML = "yaml"
+	// a parquet file of a single row group per table
+	ExportParquet = "parquet"
+)
+
+// export file extension of each format
+var exportExts = map[string]string{ExportCSV: "csv", ExportJSONL: "jsonl", ExportGo: "go", ExportYAML: "yml", ExportParquet: "parquet"}
+
+// how column values are written to export files
+const (
+	// written as is, a JSON number in jsonl
+	EncodingNumber = "number"
+	// written as is, a JSON string in jsonl so no precision is lost
+	EncodingDecimal = "decimal"
+	// YYYY-MM-DD
+	EncodingDate = "date"
+	// YYYY-MM-DDTHH:MM:SS[.ffffff], without a time zone
+	EncodingDatetime = "datetime"
+	// base64 standard encoding of the bytes
+	EncodingBase64 = "base64"
+	// the JSON document, embedded as is in jsonl
+	EncodingJSON = "json"
+	EncodingText = "text"
+)
+
+const (
+	manifestFile = "manifest.json"
+	// how csv files tell NULL values from empty strings, like LOAD DATA
+	csvNullMarker = `\N`
+)
+
+// Manifest describes the files of an export
+type Manifest struct {
+	Schema string          `json:"schema"`
+	Format string          `json:"format"`
+	Tables []ExportedTable `json:"tables"`
+}
+
+// ExportedTable is a table written to an export file
+type ExportedTable struct {
+	Name string `json:"name"`
+	// file name relative to the export dir
+	File        string               `json:"file"`
+	Rows        int64                `json:"rows"`
+	Columns     []ExportedColumn     `json:"columns"`
+	PrimaryKey  []string             `json:"primary_key"`
+	ForeignKeys []ExportedForeignKey `json:"foreign_keys"`
+	// the CREATE TABLE statement of the table
+	CreateTable string `json:"create_table"`
+}
+
+// ExportedColumn is a column of an exported table, in file order
+type ExportedColumn struct {
+	Name string `json:"name"`
+	// the column type, for example decimal(10,2) unsigned
+	Type     string `json:"type"`
+	Nullable bool   `json:"nullable"`
+	// one of the Encoding constants
+	Encoding string `json:"encoding"`
+}
+
+// ExportedForeignKey is a column referencing another table of the export
+type ExportedForeignKey struct {
+	Column           string `json:"column"`
+	ReferencedTable  string `json:"referenced_table"`
+	ReferencedColumn string `json:"referenced_column"`
+}
+
+// writes the rows of a table to its export file
+type tableEncoder interface {
+	writeRow(vals [][]byte) error
+	flush() error
+}
+
+// Export writes every row of the schema tables to dir, a file per table in the format and a manifest.json
+// describing them. NULL values are written as \N in csv files. Go files belong to the package named
+// after dir. Foreign keys are read from relSchema as the tables of a sample don't have them.
+func Export(ctx context.Context, db *sql.DB, relSchema, schema, dir, format string) (*Manifest, error) {
+	if _, ok := exportExts[format]; !ok {
+		return nil, fmt.Errorf("unknown export format %s, expected %s, %s, %s, %s or %s", format, ExportCSV, ExportJSONL, ExportGo, ExportYAML, ExportParquet)
+	}
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	tables, err := baseTables(ctx, db, schema)
+	if err != nil {
+		return nil, err
+	}
+	exported := map[string]struct{}{}
+	for _, table := range tables {
+		exported[table] = struct{}{}
+	}
+	m := &Manifest{Schema: schema, Format: format, Tables: []ExportedTable{}}
+	names := goNames(tables)
+	for _, table := range tables {
+		t, err := describeTable(ctx, db, relSchema, schema, table, exported)
+		if err != nil {
+			return nil, fmt.Errorf("describe %s: %w", table, err)
+		}
+		t.File = table + "." + exportExts[format]
+		t.Rows, err = exportTable(ctx, db, schema, t, filepath.Join(dir, t.File), format, names[table])
+		if err != nil {
+			return nil, fmt.Errorf("export %s: %w", table, err)
+		}
+		m.Tables = append(m.Tables, *t)
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return m, ioutil.WriteFile(filepath.Join(dir, manifestFile), data, 0644)
+}
+
+// ReadManifest reads the manifest of the export in dir
+func ReadManifest(dir string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, manifestFile))
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	err = json.Unmarshal(data, m)
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	return m, nil
+}
+
+// describes the table of the schema, with the foreign keys it has in relSchema to the exported tables
+func describeTable(ctx context.Context, db *sql.DB, relSchema, schema, table string, exported map[string]struct{}) (*ExportedTable, error) {
+	t := &ExportedTable{Name: table, PrimaryKey: []string{}, ForeignKeys: []ExportedForeignKey{}}
+	rows, err := db.QueryContext(ctx,
+		"SELECT column_name, data_type, column_type, is_nullable FROM information_schema.columns WHERE table_schema = ? AND table_name = ? ORDER BY ordinal_position;",
+		schema, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var col ExportedColumn
+		var dataType, nullable string
+		err = rows.Scan(&col.Name, &dataType, &col.Type, &nullable)
+		if err != nil {
+			return nil, err
+		}
+		col.Nullable = nullable == "YES"
+		col.Encoding = columnEncoding(dataType)
+		t.Columns = append(t.Columns, col)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	pk, err := getTablePrimaryKeyConstraints(ctx, db, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	if pk != nil {
+		t.PrimaryKey = pk.tableCol
+	}
+	rels, err := fowardRelationships(ctx, db, relSchema, table)
+	if err != nil {
+		return nil, err
+	}
+	for _, rel := range rels {
+		if _, ok := exported[rel.ReferencedTable]; !ok {
+			continue
+		}
+		t.ForeignKeys = append(t.ForeignKeys, ExportedForeignKey{Column: rel.Column, ReferencedTable: rel.ReferencedTable, ReferencedColumn: rel.ReferencedColumn})
+	}
+	obj := schemaObject{kind: "TABLE", name: table}
+	err = showCreate(ctx, db, schema, &obj)
+	if err != nil {
+		return nil, err
+	}
+	t.CreateTable = obj.create
+	return t, nil
+}
+
+// returns the encoding of the values of a column of the data type
+func columnEncoding(dataType string) string {
+	switch strings.ToLower(dataType) {
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint", "year", "float", "double", "real":
+		return EncodingNumber
+	case "decimal", "numeric":
+		return EncodingDecimal
+	case "date":
+		return EncodingDate
+	case "datetime", "timestamp":
+		return EncodingDatetime
+	case "binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob", "bit", "geometry", "point", "linestring", "polygon":
+		return EncodingBase64
+	case "json":
+		return EncodingJSON
+	default:
+		return EncodingText
+	}
+}
+
+// returns the text of a non NULL value in the encoding
+func encodeText(encoding string, val []byte) string {
+	switch encoding {
+	case EncodingBase64:
+		return base64.StdEncoding.EncodeToString(val)
+	case EncodingDatetime:
+		return strings.Replace(string(val), " ", "T", 1)
+	default:
+		return string(val)
+	}
+}
+
+// writes the rows of the table to path, name is the Go name of the rows of Go exports
+func exportTable(ctx context.Context, db *sql.DB, schema string, t *ExportedTable, path, format, name string) (int64, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	var enc tableEncoder
+	switch format {
+	case ExportCSV:
+		enc, err = newCSVEncoder(w, t.Columns)
+	case ExportJSONL:
+		enc = &jsonlEncoder{w: w, columns: t.Columns}
+	case ExportGo:
+		enc = newGoEncoder(w, goPackage(filepath.Base(filepath.Dir(path))), t.Name, name, t.Columns)
+	case ExportYAML:
+		enc = &yamlEncoder{w: w, columns: t.Columns}
+	case ExportParquet:
+		enc = newParquetEncoder(w, t.Columns)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	cols := make([]string, len(t.Columns))
+	for i, col := range t.Columns {
+		cols[i] = "`" + col.Name + "`"
+	}
+	query := fmt.Sprintf("SELECT %s FROM `%s`.`%s`", strings.Join(cols, ", "), schema, t.Name)
+	if len(t.PrimaryKey) > 0 {
+		// keep exports of the same rows identical
+		order := append([]string{}, t.PrimaryKey...)
+		for i := range order {
+			order[i] = "`" + order[i] + "`"
+		}
+		query += " ORDER BY " + strings.Join(order, ", ")
+	}
+	rows, err := db.QueryContext(ctx, query+";")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	var n int64
+	vals := make([][]byte, len(cols))
+	ptrs := make([]interface{}, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	for rows.Next() {
+		err = rows.Scan(ptrs...)
+		if err != nil {
+			return n, err
+		}
+		err = enc.writeRow(vals)
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err = rows.Err(); err != nil {
+		return n, err
+	}
+	err = enc.flush()
+	if err != nil {
+		return n, err
+	}
+	err = w.Flush()
+	if err != nil {
+		return n, err
+	}
+	return n, f.Close()
+}
+
+type csvEncoder struct {
+	w       *csv.Writer
+	columns []ExportedColumn
+	record  []string
+}
+
+// the first line of csv files holds the column names
+func newCSVEncoder(w *bufio.Writer, columns []ExportedColumn) (*csvEncoder, error) {
+	enc := &csvEncoder{w: csv.NewWriter(w), columns: columns, record: make([]string, len(columns))}
+	for i, col := range columns {
+		enc.record[i] = col.Name
+	}
+	return enc, enc.w.Write(enc.record)
+}
+
+func (e *csvEncoder) writeRow(vals [][]byte) error {
+	for i, val := range vals {
+		if val == nil {
+			e.record[i] = csvNullMarker
+			continue
+		}
+		e.record[i] = escapeCSVNull(encodeText(e.columns[i].Encoding, val))
+	}
+	return e.w.Write(e.record)
+}
+
+func (e *csvEncoder) flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// values reading like the NULL marker get one more backslash, `\N` is written `\\N` and `\\N` `\\\N`
+func escapeCSVNull(s string) string {
+	if strings.HasPrefix(s, `\`) && strings.TrimLeft(s, `\`) == "N" {
+		return `\` + s
+	}
+	return s
+}
+
+// writes a JSON object per row, keys in column order
+type jsonlEncoder struct {
+	w       *bufio.Writer
+	columns []ExportedColumn
+}
+
+func (e *jsonlEncoder) writeRow(vals [][]byte) error {
+	e.w.WriteByte('{')
+	for i, val := range vals {
+		if i > 0 {
+			e.w.WriteByte(',')
+		}
+		key, _ := json.Marshal(e.columns[i].Name)
+		e.w.Write(key)
+		e.w.WriteByte(':')
+		switch {
+		case val == nil:
+			e.w.WriteString("null")
+		case e.columns[i].Encoding == EncodingNumber || e.columns[i].Encoding == EncodingJSON && jsonAsIs(val):
+			e.w.Write(val)
+		default:
+			s, err := json.Marshal(encodeText(e.columns[i].Encoding, val))
+			if err != nil {
+				return err
+			}
+			e.w.Write(s)
+		}
+	}
+	e.w.WriteByte('}')
+	_, err := e.w.WriteString("\n")
+	return err
+}
+
+func (e *jsonlEncoder) flush() error {
+	return nil
+}
+
+// tells if a JSON column value is written to jsonl as is. Values that aren't valid JSON are written as a
+// string of their text, and so are documents that are a string themselves so load can tell them apart.
+func jsonAsIs(val []byte) bool {
+	return json.Valid(val) && !bytes.HasPrefix(bytes.TrimSpace(val), []byte(`"`))
+}
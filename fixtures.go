@@ -0,0 +1,258 @@
+package sampledb
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"go/format"
+	"go/token"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// initialisms kept upper case in Go names, as golint wants them
+var goInitialisms = map[string]struct{}{
+	"api": {}, "ascii": {}, "cpu": {}, "css": {}, "dns": {}, "html": {}, "http": {}, "https": {}, "id": {}, "ip": {},
+	"json": {}, "sku": {}, "sql": {}, "ssh": {}, "tcp": {}, "tls": {}, "ttl": {}, "udp": {}, "ui": {}, "uid": {},
+	"uri": {}, "url": {}, "utf8": {}, "uuid": {}, "vat": {}, "xml": {},
+}
+
+// writes a Go file with a struct type for the table and a slice literal of its rows, gofmt'ed once all
+// rows are in
+type goEncoder struct {
+	w     io.Writer
+	pkg   string
+	table string
+	// name of the rows slice, the struct type is named after it
+	name    string
+	columns []ExportedColumn
+	// Go type and field name of each column
+	types, fields []string
+	// whether the fields or the row literals refer to the time package
+	usesTime bool
+	buf      bytes.Buffer
+}
+
+func newGoEncoder(w io.Writer, pkg, table, name string, columns []ExportedColumn) *goEncoder {
+	enc := &goEncoder{w: w, pkg: pkg, table: table, name: name, columns: columns, types: make([]string, len(columns)), fields: make([]string, len(columns))}
+	for i, col := range columns {
+		enc.types[i] = goType(col)
+		enc.fields[i] = goName(col.Name)
+		if enc.types[i] == "time.Time" {
+			enc.usesTime = true
+		}
+	}
+	return enc
+}
+
+func (e *goEncoder) writeRow(vals [][]byte) error {
+	e.buf.WriteString("{")
+	for i, val := range vals {
+		lit, err := goLiteral(e.types[i], val)
+		if err != nil {
+			return fmt.Errorf("column %s: %w", e.columns[i].Name, err)
+		}
+		if lit == "" {
+			continue
+		}
+		// sql.NullTime values are time.Date literals
+		if e.types[i] == "sql.NullTime" {
+			e.usesTime = true
+		}
+		fmt.Fprintf(&e.buf, "%s: %s, ", e.fields[i], lit)
+	}
+	e.buf.WriteString("},\n")
+	return nil
+}
+
+func (e *goEncoder) flush() error {
+	// the imports are only known once every row is in
+	src := &bytes.Buffer{}
+	typeName := e.name + "Row"
+	fmt.Fprintf(src, "// Code generated by sampledb export; DO NOT EDIT.\n\npackage %s\n\n", e.pkg)
+	for _, typ := range e.types {
+		if strings.HasPrefix(typ, "sql.") {
+			fmt.Fprintf(src, "import %q\n", "database/sql")
+			break
+		}
+	}
+	if e.usesTime {
+		fmt.Fprintf(src, "import %q\n", "time")
+	}
+	fmt.Fprintf(src, "\n// %s is a row of the %s table\ntype %s struct {\n", typeName, e.table, typeName)
+	for i, col := range e.columns {
+		fmt.Fprintf(src, "%s %s `db:%q`\n", e.fields[i], e.types[i], col.Name)
+	}
+	fmt.Fprintf(src, "}\n\n// %s are the exported rows of the %s table\nvar %s = []%s{\n", e.name, e.table, e.name, typeName)
+	src.Write(e.buf.Bytes())
+	src.WriteString("}\n")
+	formatted, err := format.Source(src.Bytes())
+	if err != nil {
+		return fmt.Errorf("format generated code: %w", err)
+	}
+	_, err = e.w.Write(formatted)
+	return err
+}
+
+// returns the Go type of the column values, NULLable columns get a sql.Null type or a pointer to
+// unsigned bigints as they may not fit a sql.NullInt64
+func goType(col ExportedColumn) string {
+	typ := "string"
+	switch col.Encoding {
+	case EncodingNumber:
+		switch {
+		case strings.HasPrefix(col.Type, "float") || strings.HasPrefix(col.Type, "double") || strings.HasPrefix(col.Type, "real"):
+			typ = "float64"
+		case strings.Contains(col.Type, "unsigned") && !col.Nullable:
+			typ = "uint64"
+		case strings.Contains(col.Type, "unsigned") && strings.HasPrefix(col.Type, "bigint"):
+			// nil is NULL
+			return "*uint64"
+		default:
+			typ = "int64"
+		}
+	case EncodingDate, EncodingDatetime:
+		typ = "time.Time"
+	case EncodingBase64:
+		// nil is NULL
+		return "[]byte"
+	}
+	if !col.Nullable {
+		return typ
+	}
+	return map[string]string{"string": "sql.NullString", "int64": "sql.NullInt64", "float64": "sql.NullFloat64", "time.Time": "sql.NullTime"}[typ]
+}
+
+// returns the Go literal of a value of the type, empty for zero values
+func goLiteral(typ string, val []byte) (string, error) {
+	if val == nil {
+		return "", nil
+	}
+	var lit string
+	switch strings.TrimPrefix(typ, "sql.Null") {
+	case "[]byte":
+		return "[]byte(" + strconv.Quote(string(val)) + ")", nil
+	case "*uint64":
+		return "&[]uint64{" + string(val) + "}[0]", nil
+	case "int64", "uint64", "Int64", "float64", "Float64":
+		lit = string(val)
+	case "time.Time", "Time":
+		t, err := time.Parse("2006-01-02 15:04:05.999999999", string(val))
+		if err != nil {
+			t, err = time.Parse("2006-01-02", string(val))
+		}
+		if err != nil {
+			// zero dates
+			lit = "time.Time{}"
+			break
+		}
+		lit = fmt.Sprintf("time.Date(%d, %d, %d, %d, %d, %d, %d, time.UTC)", t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond())
+	default:
+		lit = strconv.Quote(string(val))
+	}
+	if !strings.HasPrefix(typ, "sql.Null") {
+		return lit, nil
+	}
+	return fmt.Sprintf("%s{%s: %s, Valid: true}", typ, strings.TrimPrefix(typ, "sql.Null"), lit), nil
+}
+
+// returns the exported Go name of a snake case db name
+func goName(name string) string {
+	b := &strings.Builder{}
+	for _, word := range strings.FieldsFunc(name, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		if _, ok := goInitialisms[strings.ToLower(word)]; ok {
+			b.WriteString(strings.ToUpper(word))
+			continue
+		}
+		r := []rune(word)
+		b.WriteString(string(unicode.ToUpper(r[0])) + string(r[1:]))
+	}
+	if b.Len() == 0 || !unicode.IsLetter([]rune(b.String())[0]) {
+		return "T" + b.String()
+	}
+	return b.String()
+}
+
+// returns the Go names of the rows of the tables, numbered when the names of two tables or the type of
+// one and the rows of another would collide, like users_row and users' UsersRow
+func goNames(tables []string) map[string]string {
+	names := make(map[string]string, len(tables))
+	taken := map[string]struct{}{}
+	for _, table := range tables {
+		name := goName(table)
+		for i := 2; ; i++ {
+			_, ok := taken[name]
+			_, typeOk := taken[name+"Row"]
+			if !ok && !typeOk {
+				break
+			}
+			name = goName(table) + strconv.Itoa(i)
+		}
+		taken[name] = struct{}{}
+		taken[name+"Row"] = struct{}{}
+		names[table] = name
+	}
+	return names
+}
+
+// returns the Go package name of an export dir
+func goPackage(dir string) string {
+	pkg := strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII || !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, dir)
+	if pkg == "" || !unicode.IsLetter(rune(pkg[0])) || token.Lookup(pkg).IsKeyword() {
+		return "fixtures"
+	}
+	return pkg
+}
+
+// writes the rows as a go-testfixtures YAML file, a list with a map of column values per row
+type yamlEncoder struct {
+	w       io.Writer
+	columns []ExportedColumn
+	rows    int
+}
+
+func (e *yamlEncoder) writeRow(vals [][]byte) error {
+	b := &strings.Builder{}
+	for i, val := range vals {
+		prefix := "  "
+		if i == 0 {
+			prefix = "- "
+		}
+		fmt.Fprintf(b, "%s%s: %s\n", prefix, strconv.Quote(e.columns[i].Name), yamlValue(e.columns[i].Encoding, val))
+	}
+	e.rows++
+	_, err := io.WriteString(e.w, b.String())
+	return err
+}
+
+func (e *yamlEncoder) flush() error {
+	if e.rows > 0 {
+		return nil
+	}
+	// an empty list, not a NULL document
+	_, err := io.WriteString(e.w, "[]\n")
+	return err
+}
+
+// numbers are written as is, binary values as !!binary base64 and other values as double quoted strings
+// so YAML doesn't guess their type
+func yamlValue(encoding string, val []byte) string {
+	switch {
+	case val == nil:
+		return "null"
+	case encoding == EncodingNumber:
+		return string(val)
+	case encoding == EncodingBase64:
+		return "!!binary " + strconv.Quote(base64.StdEncoding.EncodeToString(val))
+	default:
+		return strconv.Quote(string(val))
+	}
+}
@@ -0,0 +1,268 @@
+package sampledb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// rows copied by each statement of a full table copy when CopyOptions doesn't say
+const copyChunkRows = 10000
+
+// CopyOptions is how tables are copied in full
+type CopyOptions struct {
+	// rows copied by each statement, defaults to 10000
+	ChunkRows int
+	// number of statements run concurrently, defaults to 1
+	Workers int
+	// called after every chunk with the rows of the table copied so far and the rows in the target table
+	// as estimated by the engine, from one goroutine at a time
+	Progress func(table string, copied, estimated int64)
+	// rows copied of the tables that have one, every row of the others is
+	Filters map[string]TableFilter
+	// tables and views created in the sample schema, as names or patterns. all of them when empty
+	Include []string
+	// tables and views left out of the sample schema, as names or patterns
+	Exclude []string
+	// keeps the AUTO_INCREMENT counters of the target tables instead of starting over
+	KeepAutoIncrement bool
+	// keeps the foreign keys of the target tables, referencing the sample tables
+	KeepForeignKeys bool
+	// leaves the partitioning of the target tables out
+	StripPartitions bool
+}
+
+// TableFilter narrows down the rows of a table copied in full
+type TableFilter struct {
+	// SQL predicate the rows match, used as is in a WHERE clause. every row matches when empty
+	Where string
+	// most rows copied, the first ones in primary key order. no limit when zero
+	Limit int64
+}
+
+func (f TableFilter) String() string {
+	conds := []string{}
+	if f.Where != "" {
+		conds = append(conds, "WHERE "+f.Where)
+	}
+	if f.Limit > 0 {
+		conds = append(conds, fmt.Sprintf("LIMIT %d", f.Limit))
+	}
+	return strings.Join(conds, " ")
+}
+
+// a range of primary key values of a table, nil bounds are unbounded
+type copyChunk struct {
+	table        string
+	pk           []string
+	lower, upper []interface{}
+	// the filter predicate, and the most rows copied of tables without a primary key
+	where string
+	limit int64
+}
+
+// CopyTables copies every row of the tables from the target schema to the sample schema, or the rows their
+// filter picks. Rows are copied in primary key ranges of ChunkRows rows, each by a statement of its own so
+// none holds locks for long. Tables without a primary key are copied by a single statement.
+func CopyTables(ctx context.Context, db *sql.DB, targetSchema, sampleSchema string, tables []string, opts CopyOptions) error {
+	if opts.ChunkRows < 1 {
+		opts.ChunkRows = copyChunkRows
+	}
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+	estimated, err := estimateRows(ctx, db, targetSchema)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var mu sync.Mutex
+	var firstErr error
+	copied := map[string]int64{}
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+	chunks := make(chan copyChunk)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := db.Conn(ctx)
+			if err != nil {
+				fail(err)
+				return
+			}
+			defer conn.Close()
+			// rows are copied in no particular order when the sample tables keep their foreign keys
+			_, err = conn.ExecContext(ctx, "SET foreign_key_checks = 0;")
+			if err != nil {
+				fail(err)
+				return
+			}
+			defer conn.ExecContext(context.Background(), "SET foreign_key_checks = 1;")
+			for chunk := range chunks {
+				n, err := copyRange(ctx, conn, targetSchema, sampleSchema, chunk)
+				if err != nil {
+					fail(fmt.Errorf("copy %s: %w", chunk.table, err))
+					continue
+				}
+				mu.Lock()
+				copied[chunk.table] += n
+				if opts.Progress != nil {
+					opts.Progress(chunk.table, copied[chunk.table], estimated[chunk.table])
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	// the ranges are walked one table at a time while the workers copy them
+	for _, table := range tables {
+		err = splitTable(ctx, db, targetSchema, table, opts.ChunkRows, opts.Filters[table], func(chunk copyChunk) {
+			select {
+			case chunks <- chunk:
+			case <-ctx.Done():
+			}
+		})
+		if err != nil {
+			fail(fmt.Errorf("split %s: %w", table, err))
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(chunks)
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// splitTable passes the primary key ranges of chunkRows rows matching the filter the table splits into to
+// fn, in key order. The ranges stop at the filter limit.
+func splitTable(ctx context.Context, db *sql.DB, schema, table string, chunkRows int, filter TableFilter, fn func(copyChunk)) error {
+	pk, err := getTablePrimaryKeyConstraints(ctx, db, schema, table)
+	if err != nil {
+		return err
+	}
+	if len(pk.tableCol) == 0 {
+		fn(copyChunk{table: table, where: filter.Where, limit: filter.Limit})
+		return nil
+	}
+	cols, placeholders := keyTuple(pk.tableCol)
+	var lower []interface{}
+	left := filter.Limit
+	for ctx.Err() == nil {
+		n := int64(chunkRows)
+		if filter.Limit > 0 && left < n {
+			n = left
+		}
+		// the last key of the chunk starting after lower
+		conds := []string{}
+		if filter.Where != "" {
+			conds = append(conds, "("+filter.Where+")")
+		}
+		if lower != nil {
+			conds = append(conds, fmt.Sprintf("%s > %s", cols, placeholders))
+		}
+		q := fmt.Sprintf("SELECT %s FROM `%s`.`%s`", strings.Join(quoteColumns(pk.tableCol), ", "), schema, table)
+		if len(conds) > 0 {
+			q += " WHERE " + strings.Join(conds, " AND ")
+		}
+		q += fmt.Sprintf(" ORDER BY %s LIMIT 1 OFFSET %d;", strings.Join(quoteColumns(pk.tableCol), ", "), n-1)
+		upper := make([]interface{}, len(pk.tableCol))
+		ptrs := make([]interface{}, len(upper))
+		for i := range upper {
+			ptrs[i] = &upper[i]
+		}
+		err = db.QueryRowContext(ctx, q, lower...).Scan(ptrs...)
+		if err == sql.ErrNoRows {
+			// fewer than n rows are left
+			fn(copyChunk{table: table, pk: pk.tableCol, lower: lower, where: filter.Where})
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fn(copyChunk{table: table, pk: pk.tableCol, lower: lower, upper: upper, where: filter.Where})
+		if filter.Limit > 0 {
+			left -= n
+			if left == 0 {
+				return nil
+			}
+		}
+		lower = upper
+	}
+	return ctx.Err()
+}
+
+// copies the rows of the chunk and returns how many there were
+func copyRange(ctx context.Context, conn *sql.Conn, targetSchema, sampleSchema string, chunk copyChunk) (int64, error) {
+	q := fmt.Sprintf("INSERT INTO `%s`.`%s` SELECT * FROM `%s`.`%s`", sampleSchema, chunk.table, targetSchema, chunk.table)
+	conds, args := []string{}, []interface{}{}
+	if chunk.where != "" {
+		conds = append(conds, "("+chunk.where+")")
+	}
+	if len(chunk.pk) > 0 {
+		cols, placeholders := keyTuple(chunk.pk)
+		if chunk.lower != nil {
+			conds = append(conds, fmt.Sprintf("%s > %s", cols, placeholders))
+			args = append(args, chunk.lower...)
+		}
+		if chunk.upper != nil {
+			conds = append(conds, fmt.Sprintf("%s <= %s", cols, placeholders))
+			args = append(args, chunk.upper...)
+		}
+	}
+	if len(conds) > 0 {
+		q += " WHERE " + strings.Join(conds, " AND ")
+	}
+	if chunk.limit > 0 {
+		q += fmt.Sprintf(" LIMIT %d", chunk.limit)
+	}
+	res, err := conn.ExecContext(ctx, q+";", args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// returns the columns and placeholders of a key, as row constructors when it has more than one column
+func keyTuple(columns []string) (string, string) {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	if len(columns) == 1 {
+		return "`" + columns[0] + "`", placeholders
+	}
+	return "(" + strings.Join(quoteColumns(columns), ", ") + ")", "(" + placeholders + ")"
+}
+
+// returns the rows of each base table of the schema as estimated by the engine
+func estimateRows(ctx context.Context, db *sql.DB, schema string) (map[string]int64, error) {
+	rows, err := db.QueryContext(ctx, "SELECT table_name, table_rows FROM information_schema.tables WHERE table_schema = ? AND table_type = 'BASE TABLE';", schema)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	estimated := map[string]int64{}
+	for rows.Next() {
+		var tableName string
+		var tableRows sql.NullInt64
+		err = rows.Scan(&tableName, &tableRows)
+		if err != nil {
+			return nil, err
+		}
+		estimated[tableName] = tableRows.Int64
+	}
+	return estimated, rows.Err()
+}
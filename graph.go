@@ -0,0 +1,242 @@
+package sampledb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// graph formats
+const (
+	GraphDOT     = "dot"
+	GraphMermaid = "mermaid"
+	GraphJSON    = "json"
+)
+
+// edge cardinalities, from the referencing rows to the referenced ones
+const (
+	ManyToOne = "many-to-one"
+	// the referencing column is the primary key of its table
+	OneToOne = "one-to-one"
+)
+
+// Graph is the foreign key relationship graph of a schema
+type Graph struct {
+	TargetSchema string `json:"target_schema"`
+	// the anchor the reachable subgraph is computed from, empty when none was given
+	Anchor string       `json:"anchor,omitempty"`
+	Tables []GraphTable `json:"tables"`
+	Edges  []GraphEdge  `json:"edges"`
+}
+
+// GraphTable is a table of the graph
+type GraphTable struct {
+	Name string `json:"name"`
+	// how a sample from the anchor gets the table rows, one of PlanSampled, PlanReferenced or PlanFull.
+	// empty when the sample doesn't reach the table
+	Rows string `json:"rows,omitempty"`
+}
+
+// GraphEdge is a foreign key, it goes from the referencing column to the referenced one
+type GraphEdge struct {
+	Table            string `json:"table"`
+	Column           string `json:"column"`
+	ReferencedTable  string `json:"referenced_table"`
+	ReferencedColumn string `json:"referenced_column"`
+	Cardinality      string `json:"cardinality"`
+	// directions a sample from the anchor follows the edge in: foward from the referencing rows to the
+	// referenced ones, reverse from the referenced rows to the ones referencing them
+	Followed []string `json:"followed,omitempty"`
+}
+
+// SchemaGraph returns the relationship graph of the target schema. When opts has an anchor the tables and
+// edges a sample from it reaches are marked, with reachableOnly the graph is trimmed down to them.
+func SchemaGraph(ctx context.Context, db *sql.DB, opts Options, reachableOnly bool) (*Graph, error) {
+	return schemaGraph(ctx, opts.source(db), opts, reachableOnly)
+}
+
+func schemaGraph(ctx context.Context, schema SchemaReader, opts Options, reachableOnly bool) (*Graph, error) {
+	tables, err := schema.Tables(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows := map[string]string{}
+	if opts.Anchor.Table != "" {
+		plan, err := planSample(ctx, schema, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range plan.Tables {
+			rows[t.Table] = t.Rows
+		}
+	}
+	g := &Graph{TargetSchema: opts.TargetSchema, Anchor: opts.Anchor.Table, Tables: []GraphTable{}, Edges: []GraphEdge{}}
+	for _, table := range tables {
+		if reachableOnly && rows[table] == "" {
+			continue
+		}
+		g.Tables = append(g.Tables, GraphTable{Name: table, Rows: rows[table]})
+		pk, err := schema.PrimaryKey(ctx, table)
+		if err != nil {
+			return nil, err
+		}
+		rels, err := schema.FowardRelationships(ctx, table)
+		if err != nil {
+			return nil, err
+		}
+		for _, rel := range rels {
+			edge := GraphEdge{
+				Table:            rel.Table,
+				Column:           rel.Column,
+				ReferencedTable:  rel.ReferencedTable,
+				ReferencedColumn: rel.ReferencedColumn,
+				Cardinality:      ManyToOne,
+			}
+			if len(pk) == 1 && pk[0] == rel.Column {
+				edge.Cardinality = OneToOne
+			}
+			// the sample follows foward relationships of every table it copies rows to and
+			// reverse relationships of the sampled tables
+			if rows[rel.Table] == PlanSampled || rows[rel.Table] == PlanReferenced {
+				edge.Followed = append(edge.Followed, "foward")
+			}
+			if rows[rel.ReferencedTable] == PlanSampled {
+				edge.Followed = append(edge.Followed, "reverse")
+			}
+			if reachableOnly && (len(edge.Followed) == 0 || rows[rel.ReferencedTable] == "") {
+				continue
+			}
+			g.Edges = append(g.Edges, edge)
+		}
+	}
+	return g, nil
+}
+
+// Write writes the graph in one of the GraphDOT, GraphMermaid or GraphJSON formats
+func (g *Graph) Write(w io.Writer, format string) error {
+	switch format {
+	case GraphDOT:
+		return g.writeDOT(w)
+	case GraphMermaid:
+		return g.writeMermaid(w)
+	case GraphJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(g)
+	default:
+		return fmt.Errorf("unknown graph format %s, expected %s, %s or %s", format, GraphDOT, GraphMermaid, GraphJSON)
+	}
+}
+
+// short cardinality label for the edge
+func (e GraphEdge) cardinalityLabel() string {
+	if e.Cardinality == OneToOne {
+		return "1:1"
+	}
+	return "N:1"
+}
+
+func (e GraphEdge) label() string {
+	label := fmt.Sprintf("%s -> %s %s", e.Column, e.ReferencedColumn, e.cardinalityLabel())
+	if len(e.Followed) > 0 {
+		label += " (" + strings.Join(e.Followed, ", ") + ")"
+	}
+	return label
+}
+
+func (g *Graph) writeDOT(w io.Writer) error {
+	quote := func(s string) string {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+	}
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "digraph %s {\n", quote(g.TargetSchema))
+	fmt.Fprintln(b, "\trankdir=LR;")
+	fmt.Fprintln(b, "\tnode [shape=box];")
+	for _, t := range g.Tables {
+		attrs := ""
+		switch {
+		case t.Name == g.Anchor:
+			attrs = ` [style="filled,bold", fillcolor="gold"]`
+		case t.Rows == PlanSampled:
+			attrs = ` [style=filled, fillcolor="lightblue"]`
+		case t.Rows == PlanReferenced:
+			attrs = ` [style=filled, fillcolor="lightgrey"]`
+		case t.Rows == PlanFull:
+			attrs = ` [style=filled, fillcolor="palegreen"]`
+		case g.Anchor != "":
+			attrs = ` [color="grey", fontcolor="grey"]`
+		}
+		fmt.Fprintf(b, "\t%s%s;\n", quote(t.Name), attrs)
+	}
+	for _, e := range g.Edges {
+		attrs := fmt.Sprintf("label=%s", quote(e.label()))
+		switch {
+		case len(e.Followed) > 0:
+			attrs += `, penwidth=2`
+		case g.Anchor != "":
+			attrs += `, color="grey", fontcolor="grey"`
+		}
+		if e.Cardinality == OneToOne {
+			attrs += `, arrowtail=tee, dir=both`
+		}
+		fmt.Fprintf(b, "\t%s -> %s [%s];\n", quote(e.Table), quote(e.ReferencedTable), attrs)
+	}
+	fmt.Fprintln(b, "}")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (g *Graph) writeMermaid(w io.Writer) error {
+	// mermaid ids can't hold every character a table name can, tables are numbered instead
+	ids := map[string]string{}
+	quote := func(s string) string {
+		return `"` + strings.ReplaceAll(s, `"`, "#quot;") + `"`
+	}
+	b := &strings.Builder{}
+	fmt.Fprintln(b, "flowchart LR")
+	for i, t := range g.Tables {
+		ids[t.Name] = fmt.Sprintf("t%d", i)
+		class := ""
+		switch {
+		case t.Name == g.Anchor:
+			class = ":::anchor"
+		case t.Rows != "":
+			class = ":::" + t.Rows
+		case g.Anchor != "":
+			class = ":::unreached"
+		}
+		fmt.Fprintf(b, "    %s[%s]%s\n", ids[t.Name], quote(t.Name), class)
+	}
+	followed := []string{}
+	links := 0
+	for _, e := range g.Edges {
+		from, ok := ids[e.Table]
+		to, ok2 := ids[e.ReferencedTable]
+		if !ok || !ok2 {
+			// references a table of another schema
+			continue
+		}
+		arrow := "-->"
+		if len(e.Followed) > 0 {
+			arrow = "==>"
+			followed = append(followed, fmt.Sprint(links))
+		}
+		links++
+		fmt.Fprintf(b, "    %s %s|%s| %s\n", from, arrow, quote(e.label()), to)
+	}
+	if g.Anchor != "" {
+		fmt.Fprintln(b, "    classDef anchor fill:gold,stroke-width:3px")
+		fmt.Fprintf(b, "    classDef %s fill:lightblue\n", PlanSampled)
+		fmt.Fprintf(b, "    classDef %s fill:lightgrey\n", PlanReferenced)
+		fmt.Fprintf(b, "    classDef %s fill:palegreen\n", PlanFull)
+		fmt.Fprintln(b, "    classDef unreached color:grey,stroke:grey")
+		if len(followed) > 0 {
+			fmt.Fprintf(b, "    linkStyle %s stroke-width:3px\n", strings.Join(followed, ","))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
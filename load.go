@@ -0,0 +1,365 @@
+package sampledb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// most rows inserted by a single statement
+	loadBatchRows = 500
+	// most placeholders in a single statement, the server allows 65535
+	loadBatchArgs = 60000
+)
+
+// LoadOptions is how Load writes an export to a db
+type LoadOptions struct {
+	// schema the rows are loaded to, defaults to the schema the export was taken from
+	Schema string
+	// what to do when the schema exists already, one of the IfExists modes. rows already in reused
+	// schemas are kept and the rows with the same keys in the export skipped.
+	IfExists string
+	// load with foreign key checks on, tables are loaded in dependency order so this only fails when
+	// the tables reference each other in a cycle or a table references rows of itself that come later
+	Checks bool
+}
+
+// reads the rows of a table from its export file
+type tableDecoder interface {
+	// returns io.EOF after the last row
+	next() ([]interface{}, error)
+}
+
+// Load creates the tables of the export in dir and inserts their rows, tables are created and loaded
+// so each one comes after the tables it references. It returns how many rows were loaded by table.
+func Load(ctx context.Context, db *sql.DB, dir string, opts LoadOptions) (map[string]int64, error) {
+	m, err := ReadManifest(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read manifest: %w", err)
+	}
+	if m.Format != ExportCSV && m.Format != ExportJSONL {
+		return nil, fmt.Errorf("can't load %s exports, only %s and %s ones", m.Format, ExportCSV, ExportJSONL)
+	}
+	if opts.Schema == "" {
+		opts.Schema = m.Schema
+	}
+	if opts.IfExists == "" {
+		opts.IfExists = IfExistsFail
+	}
+	if !validIfExists(opts.IfExists) {
+		return nil, fmt.Errorf("unknown if exists mode %s, must be one of fail, drop, reuse or truncate", opts.IfExists)
+	}
+	order, err := loadOrder(m, opts.Checks)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_, err = conn.ExecContext(ctx, "SET foreign_key_checks = 0;")
+	if err != nil {
+		return nil, err
+	}
+	// session variables outlive the statements, restore it before the connection goes back to the pool
+	defer conn.ExecContext(context.Background(), "SET foreign_key_checks = 1;")
+	err = prepareLoadSchema(ctx, db, conn, m, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Checks {
+		_, err = conn.ExecContext(ctx, "SET foreign_key_checks = 1;")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	loaded := map[string]int64{}
+	for _, t := range order {
+		n, err := loadTable(ctx, conn, opts.Schema, filepath.Join(dir, t.File), m.Format, t, opts.IfExists == IfExistsReuse)
+		if err != nil {
+			return loaded, fmt.Errorf("load %s: %w", t.Name, err)
+		}
+		loaded[t.Name] = n
+	}
+	return loaded, nil
+}
+
+// returns the tables of the manifest in dependency order, references of a table to itself are left out.
+// Without checks tables referencing each other in a cycle are loaded by name.
+func loadOrder(m *Manifest, checks bool) ([]*ExportedTable, error) {
+	deps := map[string][]string{}
+	tables := map[string]*ExportedTable{}
+	for i, t := range m.Tables {
+		tables[t.Name] = &m.Tables[i]
+		deps[t.Name] = []string{}
+		for _, fk := range t.ForeignKeys {
+			if fk.ReferencedTable != t.Name {
+				deps[t.Name] = append(deps[t.Name], fk.ReferencedTable)
+			}
+		}
+	}
+	names, err := sortByDependencies("tables", deps)
+	if err != nil {
+		if checks {
+			return nil, fmt.Errorf("%w, load them with foreign key checks off", err)
+		}
+		names = make([]string, 0, len(tables))
+		for name := range tables {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	order := make([]*ExportedTable, len(names))
+	for i, name := range names {
+		order[i] = tables[name]
+	}
+	return order, nil
+}
+
+// creates the schema and the export tables missing from it, as the IfExists mode says
+func prepareLoadSchema(ctx context.Context, db *sql.DB, conn *sql.Conn, m *Manifest, opts LoadOptions) error {
+	exists, err := schemaExists(ctx, db, opts.Schema)
+	if err != nil {
+		return err
+	}
+	if exists {
+		switch opts.IfExists {
+		case IfExistsFail:
+			return fmt.Errorf("schema %s exists already", opts.Schema)
+		case IfExistsDrop:
+			_, err = conn.ExecContext(ctx, fmt.Sprintf("DROP DATABASE `%s`;", opts.Schema))
+			if err != nil {
+				return err
+			}
+			exists = false
+		}
+	}
+	existing := map[string]string{}
+	if exists {
+		existing, err = showFullTables(ctx, db, opts.Schema)
+		if err != nil {
+			return err
+		}
+	} else {
+		_, err = conn.ExecContext(ctx, fmt.Sprintf("CREATE DATABASE `%s`;", opts.Schema))
+		if err != nil {
+			return err
+		}
+	}
+	if opts.IfExists == IfExistsTruncate {
+		_, err = truncateTables(ctx, db, opts.Schema, existing)
+		if err != nil {
+			return err
+		}
+	}
+	// the create statements name tables without their schema
+	_, err = conn.ExecContext(ctx, fmt.Sprintf("USE `%s`;", opts.Schema))
+	if err != nil {
+		return err
+	}
+	for _, t := range m.Tables {
+		if _, ok := existing[t.Name]; ok {
+			continue
+		}
+		_, err = conn.ExecContext(ctx, t.CreateTable)
+		if err != nil {
+			return fmt.Errorf("create table %s: %w", t.Name, err)
+		}
+	}
+	return nil
+}
+
+func loadTable(ctx context.Context, conn *sql.Conn, schema, path, format string, t *ExportedTable, ignore bool) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var dec tableDecoder
+	switch format {
+	case ExportCSV:
+		dec, err = newCSVDecoder(bufio.NewReader(f), t.Columns)
+	case ExportJSONL:
+		dec = &jsonlDecoder{r: bufio.NewReader(f), columns: t.Columns}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	batchRows := loadBatchRows
+	if len(t.Columns)*batchRows > loadBatchArgs {
+		batchRows = loadBatchArgs / len(t.Columns)
+	}
+	var loaded int64
+	batch := make([][]interface{}, 0, batchRows)
+	insert := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		q, args := makeBulkInsertQuery(schema, t, batch, ignore)
+		res, err := conn.ExecContext(ctx, q, args...)
+		if err != nil {
+			return fmt.Errorf("insert failed: %w", err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		loaded += n
+		batch = batch[:0]
+		return nil
+	}
+	for {
+		row, err := dec.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return loaded, fmt.Errorf("%s: %w", path, err)
+		}
+		batch = append(batch, row)
+		if len(batch) == batchRows {
+			err = insert()
+			if err != nil {
+				return loaded, err
+			}
+		}
+	}
+	return loaded, insert()
+}
+
+// returns the statement inserting the rows, with their values in the table column order
+func makeBulkInsertQuery(schema string, t *ExportedTable, rows [][]interface{}, ignore bool) (string, []interface{}) {
+	quoted := make([]string, len(t.Columns))
+	for i, col := range t.Columns {
+		quoted[i] = fmt.Sprintf("`%s`", col.Name)
+	}
+	placeholders := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(t.Columns)), ", ") + ")"
+	values := make([]string, len(rows))
+	args := make([]interface{}, 0, len(rows)*len(t.Columns))
+	for i, row := range rows {
+		values[i] = placeholders
+		args = append(args, row...)
+	}
+	verb := "INSERT"
+	if ignore {
+		verb = "INSERT IGNORE"
+	}
+	return fmt.Sprintf("%s INTO `%s`.`%s` (%s) VALUES %s;", verb, schema, t.Name, strings.Join(quoted, ", "), strings.Join(values, ", ")), args
+}
+
+// returns the value of the text of a non NULL value in the encoding, the reverse of encodeText
+func decodeText(encoding, s string) (interface{}, error) {
+	switch encoding {
+	case EncodingBase64:
+		return base64.StdEncoding.DecodeString(s)
+	case EncodingDatetime:
+		return strings.Replace(s, "T", " ", 1), nil
+	default:
+		return s, nil
+	}
+}
+
+type csvDecoder struct {
+	r       *csv.Reader
+	columns []ExportedColumn
+}
+
+// checks the header of the csv file names the columns of the table
+func newCSVDecoder(r io.Reader, columns []ExportedColumn) (*csvDecoder, error) {
+	dec := &csvDecoder{r: csv.NewReader(r), columns: columns}
+	dec.r.FieldsPerRecord = len(columns)
+	dec.r.ReuseRecord = true
+	header, err := dec.r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	for i, col := range columns {
+		if header[i] != col.Name {
+			return nil, fmt.Errorf("expected column %s in the header, found %s", col.Name, header[i])
+		}
+	}
+	return dec, nil
+}
+
+func (d *csvDecoder) next() ([]interface{}, error) {
+	record, err := d.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	row := make([]interface{}, len(record))
+	for i, field := range record {
+		if field == csvNullMarker {
+			continue
+		}
+		row[i], err = decodeText(d.columns[i].Encoding, unescapeCSVNull(field))
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", d.columns[i].Name, err)
+		}
+	}
+	return row, nil
+}
+
+// the reverse of escapeCSVNull
+func unescapeCSVNull(s string) string {
+	if strings.HasPrefix(s, `\\`) && strings.TrimLeft(s, `\`) == "N" {
+		return s[1:]
+	}
+	return s
+}
+
+type jsonlDecoder struct {
+	r       *bufio.Reader
+	columns []ExportedColumn
+}
+
+func (d *jsonlDecoder) next() ([]interface{}, error) {
+	line, err := d.r.ReadBytes('\n')
+	if err == io.EOF && len(bytes.TrimSpace(line)) > 0 {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	obj := map[string]json.RawMessage{}
+	err = json.Unmarshal(line, &obj)
+	if err != nil {
+		return nil, err
+	}
+	row := make([]interface{}, len(d.columns))
+	for i, col := range d.columns {
+		raw, ok := obj[col.Name]
+		if !ok || string(raw) == "null" {
+			continue
+		}
+		// numbers and JSON documents are written as is, JSON values written as strings aren't, see jsonAsIs
+		if col.Encoding == EncodingNumber || col.Encoding == EncodingJSON && !bytes.HasPrefix(raw, []byte(`"`)) {
+			row[i] = string(raw)
+			continue
+		}
+		var s string
+		err = json.Unmarshal(raw, &s)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", col.Name, err)
+		}
+		row[i], err = decodeText(col.Encoding, s)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", col.Name, err)
+		}
+	}
+	return row, nil
+}
@@ -0,0 +1,116 @@
+package sampledb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// SampleManifest lists the primary keys of the rows a sample starts from, so the same sample can be taken
+// again later. Replaying one copies its rows and the rows they reference, so it can be written by hand as
+// well.
+type SampleManifest struct {
+	// seed the anchor rows were picked with, zero when they were picked at random
+	Seed         int64  `json:"seed,omitempty"`
+	TargetSchema string `json:"target_schema"`
+	// primary keys of the rows by table
+	Tables map[string]*ManifestKeys `json:"tables"`
+	// primary keys of every row whose reverse relationships the sample followed, by table. A run appending
+	// to the sample doesn't follow them again, replays ignore them
+	Sampled map[string]*ManifestKeys `json:"sampled,omitempty"`
+}
+
+// ManifestKeys are primary key tuples of a table, a value for each of the columns
+type ManifestKeys struct {
+	Columns []string        `json:"columns"`
+	Keys    [][]interface{} `json:"keys"`
+}
+
+// ReadSampleManifest reads a sample manifest written by a previous run or by hand
+func ReadSampleManifest(path string) (*SampleManifest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &SampleManifest{}
+	// numbers are kept as written, large keys don't fit a float64
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	err = dec.Decode(m)
+	if err != nil {
+		return nil, fmt.Errorf("bad sample manifest %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// Write writes the manifest as JSON to path
+func (m *SampleManifest) Write(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// adds the primary keys of the rows to the table keys, keys already in it are skipped
+func (m *SampleManifest) add(table string, pk []string, rows []map[string]interface{}) {
+	addKeys(m.Tables, table, pk, rowKeys(pk, rows))
+}
+
+// adds the primary keys of the rows to the sampled keys of the table
+func (m *SampleManifest) sampled(table string, pk []string, rows []map[string]interface{}) {
+	if m.Sampled == nil {
+		m.Sampled = map[string]*ManifestKeys{}
+	}
+	addKeys(m.Sampled, table, pk, rowKeys(pk, rows))
+}
+
+// merge adds the keys of another manifest of the same sample
+func (m *SampleManifest) merge(other *SampleManifest) {
+	for table, mk := range other.Tables {
+		addKeys(m.Tables, table, mk.Columns, mk.Keys)
+	}
+	for table, mk := range other.Sampled {
+		if m.Sampled == nil {
+			m.Sampled = map[string]*ManifestKeys{}
+		}
+		addKeys(m.Sampled, table, mk.Columns, mk.Keys)
+	}
+}
+
+func addKeys(tables map[string]*ManifestKeys, table string, pk []string, keys [][]interface{}) {
+	mk, ok := tables[table]
+	if !ok {
+		mk = &ManifestKeys{Columns: pk, Keys: [][]interface{}{}}
+		tables[table] = mk
+	}
+	seen := map[string]struct{}{}
+	for _, key := range mk.Keys {
+		seen[keyOf(pk, key)] = struct{}{}
+	}
+	for _, key := range keys {
+		if _, ok := seen[keyOf(pk, key)]; ok {
+			continue
+		}
+		seen[keyOf(pk, key)] = struct{}{}
+		mk.Keys = append(mk.Keys, key)
+	}
+}
+
+// returns the primary key values of the rows
+func rowKeys(pk []string, rows []map[string]interface{}) [][]interface{} {
+	keys := make([][]interface{}, len(rows))
+	for r, rowData := range rows {
+		key := make([]interface{}, len(pk))
+		for i, col := range pk {
+			key[i] = rowData[col]
+			// the driver hands most values over as bytes, they'd be base64 encoded
+			if b, ok := key[i].([]byte); ok {
+				key[i] = string(b)
+			}
+		}
+		keys[r] = key
+	}
+	return keys
+}
@@ -0,0 +1,561 @@
+package sampledb_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/shopsoko/sampledb"
+	"github.com/shopsoko/sampledb/sampledbtest"
+)
+
+// how many rows a random anchor picks
+const randomAnchorRows = 5
+
+// failingStore fails the writes to the failOn table, so runs can be interrupted halfway
+type failingStore struct {
+	*sampledbtest.Schema
+	failOn string
+}
+
+func (f *failingStore) WriteRows(ctx context.Context, table string, rows []map[string]interface{}) (int64, error) {
+	if table == f.failOn {
+		return 0, fmt.Errorf("writing to %s failed", table)
+	}
+	return f.Schema.WriteRows(ctx, table, rows)
+}
+
+func loadFixture(t *testing.T, name, schema string) *sampledbtest.Schema {
+	schemas, err := sampledbtest.Load(filepath.Join("test-fixtures", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schemas[schema]
+}
+
+func loadSQL(t *testing.T, script, schema string) *sampledbtest.Schema {
+	schemas, err := sampledbtest.LoadSQL(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schemas[schema]
+}
+
+// returns a script creating a single column table with rows 1 to n
+func itemsSQL(schema string, n int) string {
+	script := fmt.Sprintf("CREATE DATABASE %s;\nuse %s;\nCREATE TABLE items (id INT NOT NULL, PRIMARY KEY (id));\nINSERT INTO items VALUES ", schema, schema)
+	for i := 1; i <= n; i++ {
+		if i > 1 {
+			script += ", "
+		}
+		script += fmt.Sprintf("(%d)", i)
+	}
+	return script + ";"
+}
+
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "sampledb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func TestMemorySampleCompositeKeys(t *testing.T) {
+	source := loadFixture(t, "sample.sql", "sample")
+	dest := source.Empty()
+	_, err := sampledb.Sample(context.TODO(), nil, sampledb.Options{Source: source, Dest: dest,
+		Anchor: sampledb.Anchor{Table: "departments", Column: "dept_no", Values: []string{"d001"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only the dept_emp rows of d001, employee rows in other departments aren't sampled
+	for table, expected := range map[string][]string{
+		"departments": {"dept_no=d001"},
+		"dept_emp":    {"emp_no=10001,dept_no=d001", "emp_no=10002,dept_no=d001"},
+		"employees":   {"emp_no=10001", "emp_no=10002"},
+	} {
+		if keys := dest.Keys(table); !reflect.DeepEqual(keys, expected) {
+			t.Fatalf("expected %v in %s, got %v", expected, table, keys)
+		}
+	}
+	if orphans := dest.Orphans(); len(orphans) != 0 {
+		t.Fatalf("unexpected orphans %v", orphans)
+	}
+}
+
+func TestMemoryAppend(t *testing.T) {
+	source := loadSQL(t, `CREATE DATABASE appended;
+use appended;
+CREATE TABLE departments (dept_no CHAR(4) NOT NULL, PRIMARY KEY (dept_no));
+CREATE TABLE employees (
+    emp_no  INT     NOT NULL,
+    dept_no CHAR(4) NOT NULL,
+    PRIMARY KEY (emp_no),
+    FOREIGN KEY (dept_no) REFERENCES departments (dept_no)
+);
+CREATE TABLE titles (
+    emp_no INT         NOT NULL,
+    title  VARCHAR(50) NOT NULL,
+    PRIMARY KEY (emp_no, title),
+    FOREIGN KEY (emp_no) REFERENCES employees (emp_no)
+);
+INSERT INTO departments VALUES ('d001'), ('d002');
+INSERT INTO employees VALUES (1, 'd001'), (2, 'd001'), (3, 'd002');
+INSERT INTO titles VALUES (1, 'Engineer'), (2, 'Manager'), (3, 'Engineer');
+`, "appended")
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	for _, manifest := range []string{"", filepath.Join(dir, "keys.json")} {
+		dest := source.Empty()
+		// d001 is only copied because employee 1 references it
+		_, err := sampledb.Sample(context.TODO(), nil, sampledb.Options{TargetSchema: "appended", Source: source, Dest: dest, Manifest: manifest,
+			Anchor: sampledb.Anchor{Table: "employees", Column: "emp_no", Values: []string{"1"}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := sampledb.Sample(context.TODO(), nil, sampledb.Options{TargetSchema: "appended", Source: source, Dest: dest, Manifest: manifest,
+			IfExists: sampledb.IfExistsReuse, Anchor: sampledb.Anchor{Table: "departments", Column: "dept_no", Values: []string{"d001"}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for table, expected := range map[string][]string{
+			"departments": {"dept_no=d001"},
+			"employees":   {"emp_no=1", "emp_no=2"},
+			"titles":      {"emp_no=1,title=Engineer", "emp_no=2,title=Manager"},
+		} {
+			if keys := dest.Keys(table); !reflect.DeepEqual(keys, expected) {
+				t.Fatalf("manifest %q: expected %v in %s, got %v", manifest, expected, table, keys)
+			}
+		}
+		if res.Report.Tables["departments"].RowsCopied != 0 {
+			t.Fatalf("manifest %q: expected d001 not to be copied again, got %+v", manifest, res.Report.Tables["departments"])
+		}
+		// the manifest says employee 1 was sampled, its titles aren't looked up again
+		titleKeys := int64(2)
+		if manifest != "" {
+			titleKeys = 1
+		}
+		for _, e := range res.Report.Edges {
+			if e.Table == "titles" && e.Direction == "reverse" && e.Keys != titleKeys {
+				t.Fatalf("manifest %q: expected titles of %d employees to be looked up, got %d", manifest, titleKeys, e.Keys)
+			}
+		}
+		if manifest != "" {
+			if len(res.Manifest.Tables) != 2 || len(res.Manifest.Sampled["employees"].Keys) != 2 {
+				t.Fatalf("expected the manifests of both runs to be merged, got %+v", res.Manifest)
+			}
+		}
+	}
+}
+
+func TestMemoryResumeCompositeAnchor(t *testing.T) {
+	script := "CREATE DATABASE composite;\nuse composite;\n" +
+		"CREATE TABLE dept_emp (emp_no INT NOT NULL, dept_no CHAR(4) NOT NULL, PRIMARY KEY (emp_no, dept_no));\nINSERT INTO dept_emp VALUES "
+	// every employee is in two departments, more rows than the random anchor picks
+	for i := 1; i <= 4; i++ {
+		if i > 1 {
+			script += ", "
+		}
+		script += fmt.Sprintf("(%d, 'd001'), (%d, 'd002')", i, i)
+	}
+	source := loadSQL(t, script+";", "composite")
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	opts := sampledb.Options{TargetSchema: "composite", SampleSchema: "sample", Source: source,
+		Anchor: sampledb.Anchor{Table: "dept_emp"}, Checkpoint: filepath.Join(dir, "checkpoint.json")}
+
+	// the random anchor rows are saved by their whole primary key
+	interrupted := opts
+	interrupted.Dest = &failingStore{Schema: source.Empty(), failOn: "dept_emp"}
+	_, err := sampledb.Sample(context.TODO(), nil, interrupted)
+	if err == nil {
+		t.Fatal("expected the interrupted run to fail")
+	}
+	data, err := ioutil.ReadFile(opts.Checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp := struct {
+		Anchor struct {
+			Columns []string   `json:"columns"`
+			Keys    [][]string `json:"keys"`
+		} `json:"anchor"`
+	}{}
+	err = json.Unmarshal(data, &cp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cp.Anchor.Columns, []string{"emp_no", "dept_no"}) || len(cp.Anchor.Keys) != randomAnchorRows {
+		t.Fatalf("unexpected checkpoint anchor %+v", cp.Anchor)
+	}
+
+	sampled := source.Empty()
+	straight := opts
+	straight.Dest, straight.Checkpoint = sampled, ""
+	_, err = sampledb.Sample(context.TODO(), nil, straight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resumed := source.Empty()
+	resume := opts
+	resume.Dest, resume.Resume = resumed, true
+	_, err = sampledb.Sample(context.TODO(), nil, resume)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, keys := sampled.Keys("dept_emp"), resumed.Keys("dept_emp"); len(keys) != randomAnchorRows || !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("expected %v resumed, got %v", expected, keys)
+	}
+}
+
+func TestMemorySampleCycles(t *testing.T) {
+	source := loadSQL(t, `
+CREATE DATABASE cycles;
+use cycles;
+
+CREATE TABLE departments (
+    dept_no     CHAR(4)         NOT NULL,
+    manager     INT,
+    PRIMARY KEY (dept_no),
+    FOREIGN KEY (manager) REFERENCES employees (emp_no)
+);
+
+CREATE TABLE employees (
+    emp_no      INT             NOT NULL,
+    dept_no     CHAR(4)         NOT NULL,
+    mentor      INT,
+    PRIMARY KEY (emp_no),
+    FOREIGN KEY (dept_no) REFERENCES departments (dept_no),
+    FOREIGN KEY (mentor) REFERENCES employees (emp_no)
+);
+
+INSERT INTO departments VALUES ('d001', 10001), ('d002', 10003), ('d003', NULL);
+
+INSERT INTO employees VALUES (10001, 'd001', NULL),
+(10002, 'd001', 10001),
+(10003, 'd002', 10002),
+(10004, 'd003', NULL);
+`, "cycles")
+	for _, workers := range []int{1, 4} {
+		dest := source.Empty()
+		_, err := sampledb.Sample(context.TODO(), nil, sampledb.Options{Source: source, Dest: dest, Workers: workers,
+			Anchor: sampledb.Anchor{Table: "departments", Column: "dept_no", Values: []string{"d001"}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// d001 has employees 10001 and 10002, 10002 mentors 10003 who manages d002
+		for table, expected := range map[string][]string{
+			"departments": {"dept_no=d001", "dept_no=d002"},
+			"employees":   {"emp_no=10001", "emp_no=10002", "emp_no=10003"},
+		} {
+			if keys := dest.Keys(table); !reflect.DeepEqual(keys, expected) {
+				t.Fatalf("%d workers: expected %v in %s, got %v", workers, expected, table, keys)
+			}
+		}
+		if orphans := dest.Orphans(); len(orphans) != 0 {
+			t.Fatalf("%d workers: unexpected orphans %v", workers, orphans)
+		}
+	}
+}
+
+func TestMemorySampleLimits(t *testing.T) {
+	source := loadSQL(t, itemsSQL("limits", 8), "limits")
+	dest := source.Empty()
+	res, err := sampledb.Sample(context.TODO(), nil, sampledb.Options{Source: source, Dest: dest, Anchor: sampledb.Anchor{Table: "items"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(dest.Keys("items")); n != randomAnchorRows {
+		t.Fatalf("expected %d random rows, got %d", randomAnchorRows, n)
+	}
+	if len(res.Report.LimitsHit) != 1 {
+		t.Fatalf("expected the anchor limit to be reported, got %v", res.Report.LimitsHit)
+	}
+	if tr := res.Report.Tables["items"]; tr == nil || tr.RowsCopied != randomAnchorRows || tr.SampleRows != randomAnchorRows || tr.SourceRows != 8 {
+		t.Fatalf("unexpected report %+v", tr)
+	}
+}
+
+func TestMemorySampleBudget(t *testing.T) {
+	script := `CREATE DATABASE budget;
+use budget;
+CREATE TABLE customers (id INT NOT NULL, PRIMARY KEY (id));
+CREATE TABLE notes (
+    id          INT NOT NULL,
+    customer_id INT NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (customer_id) REFERENCES customers (id)
+);
+CREATE TABLE products (id INT NOT NULL, PRIMARY KEY (id));
+CREATE TABLE orders (
+    id          INT NOT NULL,
+    customer_id INT NOT NULL,
+    product_id  INT NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (customer_id) REFERENCES customers (id),
+    FOREIGN KEY (product_id) REFERENCES products (id)
+);
+INSERT INTO customers VALUES (1), (2);
+INSERT INTO notes VALUES (1, 1), (2, 1);
+INSERT INTO products VALUES (1), (2), (3), (4), (5), (6), (7), (8), (9), (10);
+INSERT INTO orders VALUES `
+	for i := 1; i <= 10; i++ {
+		if i > 1 {
+			script += ", "
+		}
+		script += fmt.Sprintf("(%d, 1, %d)", i, i)
+	}
+	source := loadSQL(t, script+";", "budget")
+	dest := source.Empty()
+	opts := sampledb.Options{TargetSchema: "budget", Source: source, Dest: dest, MaxRows: 5,
+		Anchor: sampledb.Anchor{Table: "customers", Column: "id", Values: []string{"1"}}}
+	res, err := sampledb.Sample(context.TODO(), nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the anchor row and its notes take three rows of the budget and the orders the other two, the
+	// products they reference are copied anyway
+	if n := len(dest.Keys("orders")); n != 2 {
+		t.Fatalf("expected 2 orders, got %d", n)
+	}
+	if n := len(dest.Keys("products")); n != 2 {
+		t.Fatalf("expected the 2 products of the orders, got %d", n)
+	}
+	if orphans := dest.Orphans(); len(orphans) != 0 {
+		t.Fatalf("unexpected orphans %v", orphans)
+	}
+	// the cut and going over the budget, customer 1 is read again through its notes and orders but it's
+	// only written once
+	if len(res.Report.LimitsHit) != 2 {
+		t.Fatalf("expected the budget to be reported, got %v", res.Report.LimitsHit)
+	}
+	if !strings.Contains(res.Report.LimitsHit[1], "copied 7 rows") {
+		t.Fatalf("expected the 7 rows copied to be counted, got %q", res.Report.LimitsHit[1])
+	}
+	var dropped int64
+	for _, e := range res.Report.Edges {
+		if e.Direction == "reverse" && e.Table == "orders" {
+			dropped = e.Dropped
+		}
+	}
+	if dropped != 8 {
+		t.Fatalf("expected 8 dropped orders, got %d", dropped)
+	}
+
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	// interrupted before it gets to the orders, the checkpoint keeps what was spent so the resumed run
+	// doesn't copy a third order. Interrupted on writing the orders, after the products they reference,
+	// the orders are pending so the resumed run doesn't cut them for the products it counted
+	for _, failOn := range []string{"notes", "orders"} {
+		interrupted := opts
+		interrupted.Dest = &failingStore{Schema: source.Empty(), failOn: failOn}
+		interrupted.Checkpoint = filepath.Join(dir, failOn+".json")
+		_, err = sampledb.Sample(context.TODO(), nil, interrupted)
+		if err == nil {
+			t.Fatalf("expected the run interrupted on %s to fail", failOn)
+		}
+		resumed := interrupted.Dest.(*failingStore)
+		resumed.failOn = ""
+		resume := interrupted
+		resume.Resume = true
+		_, err = sampledb.Sample(context.TODO(), nil, resume)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if keys := resumed.Keys("orders"); !reflect.DeepEqual(keys, dest.Keys("orders")) {
+			t.Fatalf("expected the run resumed after %s to copy %v, got %v", failOn, dest.Keys("orders"), keys)
+		}
+	}
+}
+
+func TestMemorySampleSeed(t *testing.T) {
+	source := loadSQL(t, itemsSQL("seeded", 20), "seeded")
+	sample := func(seed int64) ([]string, *sampledb.SampleManifest) {
+		dest := source.Empty()
+		res, err := sampledb.Sample(context.TODO(), nil, sampledb.Options{TargetSchema: "seeded", Source: source, Dest: dest,
+			Anchor: sampledb.Anchor{Table: "items"}, Seed: seed})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dest.Keys("items"), res.Manifest
+	}
+	keys, m := sample(42)
+	if len(keys) != randomAnchorRows {
+		t.Fatalf("expected %d random rows, got %v", randomAnchorRows, keys)
+	}
+	if again, _ := sample(42); !reflect.DeepEqual(keys, again) {
+		t.Fatalf("expected the same rows with the same seed, got %v and %v", keys, again)
+	}
+	if other, _ := sample(7); reflect.DeepEqual(keys, other) {
+		t.Fatalf("expected other rows with another seed, got %v", other)
+	}
+
+	if m.Seed != 42 || m.TargetSchema != "seeded" || len(m.Tables) != 1 {
+		t.Fatalf("unexpected manifest %+v", m)
+	}
+	mk := m.Tables["items"]
+	if !reflect.DeepEqual(mk.Columns, []string{"id"}) || len(mk.Keys) != randomAnchorRows {
+		t.Fatalf("unexpected manifest keys %+v", mk)
+	}
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "keys.json")
+	err := m.Write(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := sampledb.ReadSampleManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, m) {
+		t.Fatalf("expected %+v, read %+v", m, read)
+	}
+}
+
+func TestMemoryReplay(t *testing.T) {
+	source := loadFixture(t, "sample.sql", "sample")
+	dest := source.Empty()
+	m := &sampledb.SampleManifest{TargetSchema: "sample", Tables: map[string]*sampledb.ManifestKeys{
+		"dept_emp":  {Columns: []string{"emp_no", "dept_no"}, Keys: [][]interface{}{{json.Number("10002"), "d001"}}},
+		"employees": {Columns: []string{"emp_no"}, Keys: [][]interface{}{{"10003"}, {"10003"}}},
+	}}
+	res, err := sampledb.Sample(context.TODO(), nil, sampledb.Options{Source: source, Dest: dest, Workers: 2, Replay: m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the rows the manifest rows reference are copied, the rows referencing them aren't
+	for table, expected := range map[string][]string{
+		"departments": {"dept_no=d001"},
+		"dept_emp":    {"emp_no=10002,dept_no=d001"},
+		"employees":   {"emp_no=10002", "emp_no=10003"},
+	} {
+		if keys := dest.Keys(table); !reflect.DeepEqual(keys, expected) {
+			t.Fatalf("expected %v in %s, got %v", expected, table, keys)
+		}
+	}
+	if keys := res.Manifest.Tables["employees"].Keys; !reflect.DeepEqual(keys, [][]interface{}{{"10003"}}) {
+		t.Fatalf("unexpected manifest keys %v", keys)
+	}
+
+	for _, mk := range []*sampledb.ManifestKeys{
+		{Columns: []string{"emp_no"}, Keys: [][]interface{}{{"10001"}}},
+		{Columns: []string{"emp_no", "dept_no"}, Keys: [][]interface{}{{"10001"}}},
+		{Columns: []string{"emp_no", "dept_no"}, Keys: [][]interface{}{{"10001", "d002"}}},
+	} {
+		replay := &sampledb.SampleManifest{TargetSchema: "sample", Tables: map[string]*sampledb.ManifestKeys{"dept_emp": mk}}
+		_, err = sampledb.Sample(context.TODO(), nil, sampledb.Options{Source: source, Dest: source.Empty(), Replay: replay})
+		if err == nil {
+			t.Fatalf("expected replaying %v to fail", mk)
+		}
+	}
+}
+
+func TestPlanSample(t *testing.T) {
+	source := loadFixture(t, "reverse.sql", "reverse")
+	plan, err := sampledb.PlanSample(context.TODO(), nil, sampledb.Options{TargetSchema: "reverse", Source: source, Anchor: sampledb.Anchor{Table: "departments"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// titles reference employees, which are only referenced by the sampled rows
+	expected := []sampledb.PlannedTable{
+		{Table: "departments", Rows: sampledb.PlanSampled},
+		{Table: "dept_emp", Rows: sampledb.PlanSampled, Via: "dept_emp.dept_no -> departments.dept_no (reverse)"},
+		{Table: "employees", Rows: sampledb.PlanReferenced, Via: "dept_emp.emp_no -> employees.emp_no (foward)"},
+	}
+	if !reflect.DeepEqual(plan.Tables, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, plan.Tables)
+	}
+	if !reflect.DeepEqual(plan.Unreached, []string{"titles"}) {
+		t.Fatalf("expected titles to be left empty, got %v", plan.Unreached)
+	}
+
+	plan, err = sampledb.PlanSample(context.TODO(), nil, sampledb.Options{TargetSchema: "reverse", Source: source, Anchor: sampledb.Anchor{Table: "departments"}, NoSample: []string{"employees", "titles"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Tables[2].Rows != sampledb.PlanFull || plan.Tables[3].Table != "titles" || plan.Tables[3].Rows != sampledb.PlanFull || len(plan.Unreached) != 0 {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+
+	plan, err = sampledb.PlanSample(context.TODO(), nil, sampledb.Options{TargetSchema: "reverse", Source: source, Anchor: sampledb.Anchor{Table: "departments"}, NoSample: []string{"/^(employees|titles)$/"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Tables[2].Rows != sampledb.PlanFull || plan.Tables[3].Table != "titles" ||
+		!reflect.DeepEqual(plan.Patterns, map[string][]string{"/^(employees|titles)$/": {"employees", "titles"}}) {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+
+	opts := sampledb.Options{TargetSchema: "reverse", Source: source, Anchor: sampledb.Anchor{Table: "departments"}, NoSample: []string{"titles"},
+		NoSampleFilters: map[string]sampledb.TableFilter{"titles": {Where: "to_date > NOW()", Limit: 10}}}
+	plan, err = sampledb.PlanSample(context.TODO(), nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Tables[3].Filter != "WHERE to_date > NOW() LIMIT 10" {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+	opts.NoSample = nil
+	_, err = sampledb.PlanSample(context.TODO(), nil, opts)
+	if err == nil {
+		t.Fatal("expected filters of tables not copied in full to fail")
+	}
+}
+
+func TestSchemaGraph(t *testing.T) {
+	opts := sampledb.Options{TargetSchema: "reverse", Source: loadFixture(t, "reverse.sql", "reverse"), Anchor: sampledb.Anchor{Table: "departments"}}
+	g, err := sampledb.SchemaGraph(context.TODO(), nil, opts, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Tables) != 4 || len(g.Edges) != 3 {
+		t.Fatalf("expected 4 tables and 3 edges, got %+v", g)
+	}
+	followed := map[string][]string{}
+	for _, e := range g.Edges {
+		if e.Cardinality != sampledb.ManyToOne {
+			t.Fatalf("expected %s.%s to be many-to-one, got %s", e.Table, e.Column, e.Cardinality)
+		}
+		followed[e.Table+"."+e.Column] = e.Followed
+	}
+	expected := map[string][]string{
+		"dept_emp.dept_no": {"foward", "reverse"},
+		"dept_emp.emp_no":  {"foward"},
+		// titles aren't sampled, the sample only reaches employees through dept_emp
+		"titles.emp_no": nil,
+	}
+	if !reflect.DeepEqual(followed, expected) {
+		t.Fatalf("expected followed edges %v, got %v", expected, followed)
+	}
+
+	g, err = sampledb.SchemaGraph(context.TODO(), nil, opts, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Tables) != 3 || len(g.Edges) != 2 {
+		t.Fatalf("expected the reachable subgraph to have 3 tables and 2 edges, got %+v", g)
+	}
+	for format, want := range map[string]string{
+		sampledb.GraphDOT:     `"dept_emp" -> "departments" [label="dept_no -> dept_no N:1 (foward, reverse)", penwidth=2];`,
+		sampledb.GraphMermaid: `t1 ==>|"dept_no -> dept_no N:1 (foward, reverse)"| t0`,
+		sampledb.GraphJSON:    `"followed": [`,
+	} {
+		b := &strings.Builder{}
+		err = g.Write(b, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(b.String(), want) {
+			t.Fatalf("expected the %s graph to contain %s, got\n%s", format, want, b.String())
+		}
+	}
+}
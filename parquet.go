@@ -0,0 +1,466 @@
+package sampledb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parquet files start and end with it
+const parquetMagic = "PAR1"
+
+// parquet physical types, repetitions, converted types, logical types and encodings, from parquet.thrift
+const (
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetRequired = 0
+	parquetOptional = 1
+
+	parquetUTF8            = 0
+	parquetDecimal         = 5
+	parquetDate            = 6
+	parquetTimestampMicros = 10
+	parquetUint64          = 14
+	parquetJSON            = 19
+
+	// the LogicalType union fields
+	parquetLogicalString    = 1
+	parquetLogicalDecimal   = 5
+	parquetLogicalDate      = 6
+	parquetLogicalTimestamp = 8
+	parquetLogicalInteger   = 10
+	parquetLogicalJSON      = 12
+
+	parquetPlain = 0
+	parquetRLE   = 3
+)
+
+// thrift compact protocol field types
+const (
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftI8     = 3
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// writes a parquet file of a single row group holding every row, with a PLAIN encoded and uncompressed
+// page per column. The columns are kept in memory until flush, exports are samples.
+type parquetEncoder struct {
+	w       io.Writer
+	columns []parquetColumn
+	rows    int64
+}
+
+// a column of a parquet file and the page it's encoded to
+type parquetColumn struct {
+	ExportedColumn
+	typ, converted int
+	// digits of decimals
+	precision, scale int
+	// PLAIN encoded non NULL values and whether each value is defined
+	values  bytes.Buffer
+	defined []bool
+}
+
+func newParquetEncoder(w io.Writer, columns []ExportedColumn) *parquetEncoder {
+	enc := &parquetEncoder{w: w, columns: make([]parquetColumn, len(columns))}
+	for i, col := range columns {
+		pc := parquetColumn{ExportedColumn: col, typ: parquetByteArray, converted: parquetUTF8}
+		switch col.Encoding {
+		case EncodingNumber:
+			pc.typ, pc.converted = parquetInt64, -1
+			switch {
+			case strings.HasPrefix(col.Type, "float") || strings.HasPrefix(col.Type, "double") || strings.HasPrefix(col.Type, "real"):
+				pc.typ = parquetDouble
+			case strings.Contains(col.Type, "unsigned"):
+				pc.converted = parquetUint64
+			}
+		case EncodingBase64:
+			pc.converted = -1
+		case EncodingJSON:
+			pc.converted = parquetJSON
+		case EncodingDate:
+			pc.typ, pc.converted = parquetInt32, parquetDate
+		case EncodingDatetime:
+			pc.typ, pc.converted = parquetInt64, parquetTimestampMicros
+		case EncodingDecimal:
+			pc.converted = parquetDecimal
+			pc.precision, pc.scale = decimalDigits(col.Type)
+		}
+		enc.columns[i] = pc
+	}
+	return enc
+}
+
+func (e *parquetEncoder) writeRow(vals [][]byte) error {
+	for i, val := range vals {
+		col := &e.columns[i]
+		col.defined = append(col.defined, val != nil)
+		if val == nil {
+			continue
+		}
+		switch {
+		case col.converted == parquetDate:
+			t, err := time.Parse("2006-01-02", string(val))
+			if err != nil {
+				return fmt.Errorf("column %s: %w", col.Name, err)
+			}
+			// days since the epoch
+			binary.Write(&col.values, binary.LittleEndian, int32(t.Unix()/(24*60*60)))
+		case col.converted == parquetTimestampMicros:
+			t, err := time.Parse("2006-01-02 15:04:05.999999", string(val))
+			if err != nil {
+				return fmt.Errorf("column %s: %w", col.Name, err)
+			}
+			binary.Write(&col.values, binary.LittleEndian, t.UnixNano()/int64(time.Microsecond))
+		case col.converted == parquetDecimal:
+			b, err := decimalBytes(string(val), col.scale)
+			if err != nil {
+				return fmt.Errorf("column %s: %w", col.Name, err)
+			}
+			binary.Write(&col.values, binary.LittleEndian, uint32(len(b)))
+			col.values.Write(b)
+		case col.typ == parquetInt64:
+			var n uint64
+			var err error
+			if col.converted == parquetUint64 {
+				n, err = strconv.ParseUint(string(val), 10, 64)
+			} else {
+				var signed int64
+				signed, err = strconv.ParseInt(string(val), 10, 64)
+				n = uint64(signed)
+			}
+			if err != nil {
+				return fmt.Errorf("column %s: %w", col.Name, err)
+			}
+			binary.Write(&col.values, binary.LittleEndian, n)
+		case col.typ == parquetDouble:
+			f, err := strconv.ParseFloat(string(val), 64)
+			if err != nil {
+				return fmt.Errorf("column %s: %w", col.Name, err)
+			}
+			binary.Write(&col.values, binary.LittleEndian, math.Float64bits(f))
+		default:
+			// the bytes of binary columns, the csv text of the others
+			if col.converted != -1 {
+				val = []byte(encodeText(col.Encoding, val))
+			}
+			binary.Write(&col.values, binary.LittleEndian, uint32(len(val)))
+			col.values.Write(val)
+		}
+	}
+	e.rows++
+	return nil
+}
+
+func (e *parquetEncoder) flush() error {
+	buf := &bytes.Buffer{}
+	buf.WriteString(parquetMagic)
+	chunks := &thriftWriter{}
+	var groupSize int64
+	if e.rows > 0 {
+		chunks.listHeader(thriftStruct, len(e.columns))
+	}
+	for i := range e.columns {
+		if e.rows == 0 {
+			break
+		}
+		col := &e.columns[i]
+		page := &bytes.Buffer{}
+		if col.Nullable {
+			levels := parquetLevels(col.defined)
+			binary.Write(page, binary.LittleEndian, uint32(len(levels)))
+			page.Write(levels)
+		}
+		page.Write(col.values.Bytes())
+
+		header := &thriftWriter{}
+		header.i32(1, 0) // DATA_PAGE
+		header.i32(2, int32(page.Len()))
+		header.i32(3, int32(page.Len()))
+		header.beginStruct(5)
+		header.i32(1, int32(e.rows))
+		header.i32(2, parquetPlain)
+		header.i32(3, parquetRLE)
+		header.i32(4, parquetRLE)
+		header.end()
+		header.end()
+
+		offset := int64(buf.Len())
+		size := int64(header.buf.Len() + page.Len())
+		groupSize += size
+		buf.Write(header.buf.Bytes())
+		buf.Write(page.Bytes())
+
+		// ColumnChunk
+		chunks.beginElem()
+		chunks.i64(2, offset)
+		chunks.beginStruct(3)
+		chunks.i32(1, int32(col.typ))
+		chunks.fieldHeader(2, thriftList)
+		chunks.listHeader(thriftI32, 2)
+		chunks.varint(zigzag(parquetPlain))
+		chunks.varint(zigzag(parquetRLE))
+		chunks.fieldHeader(3, thriftList)
+		chunks.listHeader(thriftBinary, 1)
+		chunks.binary(col.Name)
+		chunks.i32(4, 0) // UNCOMPRESSED
+		chunks.i64(5, e.rows)
+		chunks.i64(6, size)
+		chunks.i64(7, size)
+		chunks.i64(9, offset)
+		chunks.end()
+		chunks.end()
+	}
+
+	// FileMetaData
+	meta := &thriftWriter{}
+	meta.i32(1, 1)
+	meta.fieldHeader(2, thriftList)
+	meta.listHeader(thriftStruct, len(e.columns)+1)
+	meta.beginElem()
+	meta.string(4, "schema")
+	meta.i32(5, int32(len(e.columns)))
+	meta.end()
+	for _, col := range e.columns {
+		meta.beginElem()
+		meta.i32(1, int32(col.typ))
+		repetition := int32(parquetRequired)
+		if col.Nullable {
+			repetition = parquetOptional
+		}
+		meta.i32(3, repetition)
+		meta.string(4, col.Name)
+		if col.converted != -1 {
+			meta.i32(6, int32(col.converted))
+		}
+		if col.converted == parquetDecimal {
+			meta.i32(7, int32(col.scale))
+			meta.i32(8, int32(col.precision))
+		}
+		col.logicalType(meta)
+		meta.end()
+	}
+	meta.i64(3, e.rows)
+	meta.fieldHeader(4, thriftList)
+	if e.rows == 0 {
+		meta.listHeader(thriftStruct, 0)
+	} else {
+		meta.listHeader(thriftStruct, 1)
+		// RowGroup
+		meta.beginElem()
+		meta.fieldHeader(1, thriftList)
+		meta.buf.Write(chunks.buf.Bytes())
+		meta.i64(2, groupSize)
+		meta.i64(3, e.rows)
+		meta.end()
+	}
+	meta.string(6, "sampledb")
+	meta.end()
+
+	buf.Write(meta.buf.Bytes())
+	binary.Write(buf, binary.LittleEndian, uint32(meta.buf.Len()))
+	buf.WriteString(parquetMagic)
+	_, err := e.w.Write(buf.Bytes())
+	return err
+}
+
+// writes the LogicalType of the column matching its converted type, readers that only know converted types
+// skip it
+func (col *parquetColumn) logicalType(w *thriftWriter) {
+	if col.converted == -1 {
+		return
+	}
+	w.beginStruct(10)
+	switch col.converted {
+	case parquetUTF8:
+		w.beginStruct(parquetLogicalString)
+	case parquetJSON:
+		w.beginStruct(parquetLogicalJSON)
+	case parquetDate:
+		w.beginStruct(parquetLogicalDate)
+	case parquetUint64:
+		w.beginStruct(parquetLogicalInteger)
+		w.i8(1, 64)
+		w.bool(2, false)
+	case parquetTimestampMicros:
+		w.beginStruct(parquetLogicalTimestamp)
+		w.bool(1, true)
+		// the TimeUnit union, MICROS
+		w.beginStruct(2)
+		w.beginStruct(2)
+		w.end()
+		w.end()
+	case parquetDecimal:
+		w.beginStruct(parquetLogicalDecimal)
+		w.i32(1, int32(col.scale))
+		w.i32(2, int32(col.precision))
+	}
+	w.end()
+	w.end()
+}
+
+// returns the precision and scale of a decimal column type like decimal(10,2) unsigned, MySQL defaults
+// to decimal(10,0)
+func decimalDigits(typ string) (int, int) {
+	precision, scale := 10, 0
+	if start, end := strings.IndexByte(typ, '('), strings.IndexByte(typ, ')'); start >= 0 && end > start {
+		digits := strings.Split(typ[start+1:end], ",")
+		precision, _ = strconv.Atoi(strings.TrimSpace(digits[0]))
+		if len(digits) > 1 {
+			scale, _ = strconv.Atoi(strings.TrimSpace(digits[1]))
+		}
+	}
+	return precision, scale
+}
+
+// returns the unscaled value of a decimal as a big-endian two's complement integer, the parquet encoding
+// of decimals stored as byte arrays
+func decimalBytes(s string, scale int) ([]byte, error) {
+	digits, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		digits, frac = s[:i], s[i+1:]
+	}
+	if len(frac) < scale {
+		frac += strings.Repeat("0", scale-len(frac))
+	}
+	n, ok := new(big.Int).SetString(digits+frac, 10)
+	if !ok {
+		return nil, fmt.Errorf("bad decimal %q", s)
+	}
+	if n.Sign() >= 0 {
+		b := n.Bytes()
+		// a leading one bit would read as a negative number
+		if len(b) == 0 || b[0]&0x80 != 0 {
+			b = append([]byte{0}, b...)
+		}
+		return b, nil
+	}
+	// 2^(8*size) + n for the smallest size the negative number fits in
+	size := n.BitLen()/8 + 1
+	complement := new(big.Int).Lsh(big.NewInt(1), uint(8*size))
+	return complement.Add(complement, n).Bytes(), nil
+}
+
+// returns the definition levels of a column as runs of the RLE/bit-packing hybrid encoding, 1 for defined
+// values and 0 for NULLs, with a bit width of 1
+func parquetLevels(defined []bool) []byte {
+	w := &thriftWriter{}
+	for i := 0; i < len(defined); {
+		run := 1
+		for i+run < len(defined) && defined[i+run] == defined[i] {
+			run++
+		}
+		w.varint(uint64(run) << 1)
+		if defined[i] {
+			w.buf.WriteByte(1)
+		} else {
+			w.buf.WriteByte(0)
+		}
+		i += run
+	}
+	return w.buf.Bytes()
+}
+
+// writes thrift compact protocol structs, the parquet metadata encoding
+type thriftWriter struct {
+	buf bytes.Buffer
+	// the last field id of each struct being written, field ids are written as deltas
+	lastIDs []int
+	lastID  int
+}
+
+func (w *thriftWriter) varint(n uint64) {
+	var b [binary.MaxVarintLen64]byte
+	w.buf.Write(b[:binary.PutUvarint(b[:], n)])
+}
+
+func zigzag(n int64) uint64 {
+	return uint64((n << 1) ^ (n >> 63))
+}
+
+func (w *thriftWriter) fieldHeader(id, typ int) {
+	if delta := id - w.lastID; delta > 0 && delta <= 15 {
+		w.buf.WriteByte(byte(delta<<4 | typ))
+	} else {
+		w.buf.WriteByte(byte(typ))
+		w.varint(zigzag(int64(id)))
+	}
+	w.lastID = id
+}
+
+func (w *thriftWriter) listHeader(elemType, size int) {
+	if size < 15 {
+		w.buf.WriteByte(byte(size<<4 | elemType))
+		return
+	}
+	w.buf.WriteByte(byte(0xf0 | elemType))
+	w.varint(uint64(size))
+}
+
+func (w *thriftWriter) bool(id int, b bool) {
+	if b {
+		w.fieldHeader(id, thriftTrue)
+	} else {
+		w.fieldHeader(id, thriftFalse)
+	}
+}
+
+func (w *thriftWriter) i8(id int, n int8) {
+	w.fieldHeader(id, thriftI8)
+	w.buf.WriteByte(byte(n))
+}
+
+func (w *thriftWriter) i32(id int, n int32) {
+	w.fieldHeader(id, thriftI32)
+	w.varint(zigzag(int64(n)))
+}
+
+func (w *thriftWriter) i64(id int, n int64) {
+	w.fieldHeader(id, thriftI64)
+	w.varint(zigzag(n))
+}
+
+func (w *thriftWriter) binary(s string) {
+	w.varint(uint64(len(s)))
+	w.buf.WriteString(s)
+}
+
+func (w *thriftWriter) string(id int, s string) {
+	w.fieldHeader(id, thriftBinary)
+	w.binary(s)
+}
+
+func (w *thriftWriter) beginStruct(id int) {
+	w.fieldHeader(id, thriftStruct)
+	w.beginElem()
+}
+
+// starts a struct written as a list element
+func (w *thriftWriter) beginElem() {
+	w.lastIDs = append(w.lastIDs, w.lastID)
+	w.lastID = 0
+}
+
+// ends the struct being written, the top level one when no other is
+func (w *thriftWriter) end() {
+	w.buf.WriteByte(0)
+	w.lastID = 0
+	if len(w.lastIDs) > 0 {
+		w.lastID = w.lastIDs[len(w.lastIDs)-1]
+		w.lastIDs = w.lastIDs[:len(w.lastIDs)-1]
+	}
+}
@@ -0,0 +1,137 @@
+package sampledb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// tells if the table selection is a regular expression between slashes like /^ref_/
+func isRegexPattern(pattern string) bool {
+	return len(pattern) > 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/")
+}
+
+// tells if the table selection is a glob or a regular expression rather than a table name
+func isTablePattern(pattern string) bool {
+	return isRegexPattern(pattern) || strings.ContainsAny(pattern, "*?[")
+}
+
+// matchTables returns the tables matching any of the patterns in table order, along with the tables each
+// pattern matched. Patterns are table names, globs like lookup_* or regular expressions between slashes
+// like /^(ref|lookup)_/, which match anywhere in the name unless anchored. Every pattern has to match a
+// table.
+func matchTables(patterns, tables []string) ([]string, map[string][]string, error) {
+	byPattern := map[string][]string{}
+	matched := map[string]struct{}{}
+	for _, pattern := range patterns {
+		var match func(table string) bool
+		switch {
+		case isRegexPattern(pattern):
+			re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+			if err != nil {
+				return nil, nil, fmt.Errorf("bad table pattern %s: %w", pattern, err)
+			}
+			match = re.MatchString
+		case isTablePattern(pattern):
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, nil, fmt.Errorf("bad table pattern %s: %w", pattern, err)
+			}
+			match = func(table string) bool {
+				ok, _ := path.Match(pattern, table)
+				return ok
+			}
+		default:
+			match = func(table string) bool { return table == pattern }
+		}
+		byPattern[pattern] = []string{}
+		for _, table := range tables {
+			if match(table) {
+				byPattern[pattern] = append(byPattern[pattern], table)
+				matched[table] = struct{}{}
+			}
+		}
+		if len(byPattern[pattern]) == 0 {
+			if !isTablePattern(pattern) {
+				return nil, nil, fmt.Errorf("table %s doesn't exist", pattern)
+			}
+			return nil, nil, fmt.Errorf("table pattern %s matches no table", pattern)
+		}
+	}
+	names := []string{}
+	for _, table := range tables {
+		if _, ok := matched[table]; ok {
+			names = append(names, table)
+		}
+	}
+	return names, byPattern, nil
+}
+
+// returns the base tables of the schema matching the patterns, see matchTables
+func resolveTables(ctx context.Context, db *sql.DB, schema string, patterns []string) ([]string, error) {
+	if len(patterns) == 0 {
+		return []string{}, nil
+	}
+	tables, err := baseTables(ctx, db, schema)
+	if err != nil {
+		return nil, err
+	}
+	names, _, err := matchTables(patterns, tables)
+	if err != nil {
+		return nil, fmt.Errorf("%w in %s", err, schema)
+	}
+	return names, nil
+}
+
+// returns the tables and views of the schema matching the include patterns, or all of them when there are
+// none, and none of the exclude patterns, see matchTables
+func selectTables(ctx context.Context, db *sql.DB, schema string, include, exclude []string) (map[string]struct{}, error) {
+	all, err := showFullTables(ctx, db, schema)
+	if err != nil {
+		return nil, fmt.Errorf("show tables: %w", err)
+	}
+	names := setKeys(setOf(all))
+	tables := names
+	if len(include) > 0 {
+		tables, _, err = matchTables(include, names)
+		if err != nil {
+			return nil, fmt.Errorf("included tables: %w in %s", err, schema)
+		}
+	}
+	selected := map[string]struct{}{}
+	for _, table := range tables {
+		selected[table] = struct{}{}
+	}
+	if len(exclude) > 0 {
+		excluded, _, err := matchTables(exclude, names)
+		if err != nil {
+			return nil, fmt.Errorf("excluded tables: %w in %s", err, schema)
+		}
+		for _, table := range excluded {
+			delete(selected, table)
+		}
+	}
+	return selected, nil
+}
+
+// returns the keys of the map as a set
+func setOf(m map[string]string) map[string]struct{} {
+	set := make(map[string]struct{}, len(m))
+	for key := range m {
+		set[key] = struct{}{}
+	}
+	return set
+}
+
+// returns the keys of the set in order
+func setKeys(set map[string]struct{}) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,167 @@
+package sampledb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// how a planned table gets its rows
+const (
+	// rows are sampled from the anchor or from the sampled rows referencing them
+	PlanSampled = "sampled"
+	// only rows referenced by the sampled rows are copied
+	PlanReferenced = "referenced"
+	// every row is copied
+	PlanFull = "full"
+)
+
+// Plan is what a sampling run would do with each table, without copying any rows
+type Plan struct {
+	TargetSchema string `json:"target_schema"`
+	Anchor       string `json:"anchor"`
+	// tables in the order they're first reached from the anchor, tables copied in full come last
+	Tables []PlannedTable `json:"tables"`
+	// tables that won't get any rows
+	Unreached []string `json:"unreached"`
+	// the tables each table pattern copied in full matched
+	Patterns map[string][]string `json:"patterns,omitempty"`
+}
+
+// PlannedTable is a table a sampling run copies rows to
+type PlannedTable struct {
+	Table string `json:"table"`
+	// one of PlanSampled, PlanReferenced or PlanFull
+	Rows string `json:"rows"`
+	// the relationship the table is first reached through, empty for the anchor and tables copied in full
+	Via string `json:"via,omitempty"`
+	// the rows of a table copied in full that are copied, for example WHERE active = 1 LIMIT 100
+	Filter string `json:"filter,omitempty"`
+}
+
+// PlanSample returns the plan of the sampling run opts describe
+func PlanSample(ctx context.Context, db *sql.DB, opts Options) (*Plan, error) {
+	return planSample(ctx, opts.source(db), opts)
+}
+
+func planSample(ctx context.Context, schema SchemaReader, opts Options) (*Plan, error) {
+	tables, err := schema.Tables(ctx)
+	if err != nil {
+		return nil, err
+	}
+	exists := map[string]struct{}{}
+	for _, table := range tables {
+		exists[table] = struct{}{}
+	}
+	if _, ok := exists[opts.Anchor.Table]; !ok {
+		return nil, fmt.Errorf("anchor table %s doesn't exist in %s", opts.Anchor.Table, opts.TargetSchema)
+	}
+	plan := &Plan{TargetSchema: opts.TargetSchema, Anchor: opts.Anchor.Table, Tables: []PlannedTable{}, Unreached: []string{}, Patterns: map[string][]string{}}
+	reached := map[string]struct{}{}
+	reach := func(table, rows, via string) bool {
+		if _, ok := reached[table]; ok {
+			return false
+		}
+		reached[table] = struct{}{}
+		plan.Tables = append(plan.Tables, PlannedTable{Table: table, Rows: rows, Via: via})
+		return true
+	}
+
+	// sample follows the reverse relationships of sampled tables, both follow foward relationships
+	sampled := []string{opts.Anchor.Table}
+	reach(opts.Anchor.Table, PlanSampled, "")
+	referenced := []string{}
+	for i := 0; i < len(sampled); i++ {
+		rels, err := schema.ReverseRelationships(ctx, sampled[i])
+		if err != nil {
+			return nil, err
+		}
+		for _, rel := range rels {
+			if reach(rel.Table, PlanSampled, relString(rel, "reverse")) {
+				sampled = append(sampled, rel.Table)
+			}
+		}
+		referenced = append(referenced, sampled[i])
+	}
+	for i := 0; i < len(referenced); i++ {
+		rels, err := schema.FowardRelationships(ctx, referenced[i])
+		if err != nil {
+			return nil, err
+		}
+		for _, rel := range rels {
+			if reach(rel.ReferencedTable, PlanReferenced, relString(rel, "foward")) {
+				referenced = append(referenced, rel.ReferencedTable)
+			}
+		}
+	}
+
+	noSample, byPattern, err := matchTables(opts.NoSample, tables)
+	if err != nil {
+		return nil, fmt.Errorf("tables copied in full: %w in %s", err, opts.TargetSchema)
+	}
+	for pattern, matched := range byPattern {
+		if isTablePattern(pattern) {
+			plan.Patterns[pattern] = matched
+		}
+	}
+	full := map[string]struct{}{}
+	for _, table := range noSample {
+		full[table] = struct{}{}
+		if _, ok := reached[table]; !ok {
+			reach(table, PlanFull, "")
+		}
+		// the rows are copied in full whatever we reached the table through
+		for i := range plan.Tables {
+			if plan.Tables[i].Table == table {
+				plan.Tables[i].Rows = PlanFull
+				plan.Tables[i].Filter = opts.NoSampleFilters[table].String()
+			}
+		}
+	}
+	for table := range opts.NoSampleFilters {
+		if _, ok := full[table]; !ok {
+			return nil, fmt.Errorf("filtered table %s isn't copied in full", table)
+		}
+	}
+	for _, table := range tables {
+		if _, ok := reached[table]; !ok {
+			plan.Unreached = append(plan.Unreached, table)
+		}
+	}
+	return plan, nil
+}
+
+func relString(rel ForeignKey, direction string) string {
+	return fmt.Sprintf("%s.%s -> %s.%s (%s)", rel.Table, rel.Column, rel.ReferencedTable, rel.ReferencedColumn, direction)
+}
+
+// Print writes the plan as a human readable table
+func (p *Plan) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "TABLE\tROWS\tVIA\tFILTER")
+	for _, t := range p.Tables {
+		via := t.Via
+		if t.Table == p.Anchor {
+			via = "anchor"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", t.Table, t.Rows, via, t.Filter)
+	}
+	if len(p.Unreached) > 0 {
+		fmt.Fprintf(tw, "\nleft empty: %v\n", p.Unreached)
+	}
+	patterns := []string{}
+	for pattern := range p.Patterns {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for i, pattern := range patterns {
+		if i == 0 {
+			fmt.Fprintln(tw)
+		}
+		fmt.Fprintf(tw, "%s matched %v\n", pattern, p.Patterns[pattern])
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,137 @@
+package sampledb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// Refresh re-reads every row of the sample schema from the target schema by primary key. Changed rows are
+// updated, rows deleted from the target schema are removed and the rows they now reference via foreign
+// keys are copied. The triggers of the sample schema are dropped while rows change and created again after.
+func Refresh(ctx context.Context, db *sql.DB, targetSchema, sampleSchema string) (err error) {
+	tables, err := showFullTables(ctx, db, sampleSchema)
+	if err != nil {
+		return err
+	}
+	tableNames := []string{}
+	for table, tableType := range tables {
+		if tableType == "BASE TABLE" {
+			tableNames = append(tableNames, table)
+		}
+	}
+	sort.Strings(tableNames)
+
+	// triggers would fire on the rows we update, remove and copy
+	err = dropTriggers(ctx, db, sampleSchema)
+	if err != nil {
+		return fmt.Errorf("drop triggers: %w", err)
+	}
+	// they're created again when we fail as well, the sample shouldn't be left without them
+	defer func() {
+		terr := CopyTriggers(context.Background(), db, targetSchema, sampleSchema)
+		if terr != nil && err == nil {
+			err = fmt.Errorf("copy triggers: %w", terr)
+		}
+	}()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// rows are removed and added in no particular order
+	_, err = conn.ExecContext(ctx, "SET foreign_key_checks = 0;")
+	if err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), "SET foreign_key_checks = 1;")
+
+	for _, table := range tableNames {
+		tblPk, err := getTablePrimaryKeyConstraints(ctx, db, targetSchema, table)
+		if err != nil {
+			return err
+		}
+		if len(tblPk.tableCol) == 0 {
+			log.Printf("skipping %s, it has no primary key\n", table)
+			continue
+		}
+		cols, err := tableColumns(ctx, db, targetSchema, table)
+		if err != nil {
+			return err
+		}
+		pkCols := map[string]struct{}{}
+		joinOn := []string{}
+		for _, col := range tblPk.tableCol {
+			pkCols[col] = struct{}{}
+			joinOn = append(joinOn, fmt.Sprintf("s.`%s` = t.`%s`", col, col))
+		}
+		set := []string{}
+		for _, col := range cols {
+			if _, isPk := pkCols[col]; !isPk {
+				set = append(set, fmt.Sprintf("s.`%s` = t.`%s`", col, col))
+			}
+		}
+
+		var updated int64
+		if len(set) > 0 {
+			res, err := conn.ExecContext(ctx, fmt.Sprintf("UPDATE `%s`.`%s` s JOIN `%s`.`%s` t ON %s SET %s;",
+				sampleSchema, table, targetSchema, table, strings.Join(joinOn, " AND "), strings.Join(set, ", ")))
+			if err != nil {
+				return fmt.Errorf("update %s: %w", table, err)
+			}
+			updated, err = res.RowsAffected()
+			if err != nil {
+				return err
+			}
+		}
+		res, err := conn.ExecContext(ctx, fmt.Sprintf("DELETE s FROM `%s`.`%s` s LEFT JOIN `%s`.`%s` t ON %s WHERE t.`%s` IS NULL;",
+			sampleSchema, table, targetSchema, table, strings.Join(joinOn, " AND "), tblPk.tableCol[0]))
+		if err != nil {
+			return fmt.Errorf("remove deleted rows from %s: %w", table, err)
+		}
+		removed, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		log.Printf("refresh %s: %d rows updated, %d rows removed\n", table, updated, removed)
+	}
+
+	// updated rows may reference rows we don't have yet, which may reference more of them in turn
+	rels := []ForeignKey{}
+	for _, table := range tableNames {
+		tableRels, err := fowardRelationships(ctx, db, targetSchema, table)
+		if err != nil {
+			return err
+		}
+		rels = append(rels, tableRels...)
+	}
+	for {
+		var inserted int64
+		for _, rel := range rels {
+			if _, exists := tables[rel.ReferencedTable]; !exists {
+				continue
+			}
+			q := fmt.Sprintf("INSERT IGNORE INTO `%s`.`%s` SELECT DISTINCT r.* FROM `%s`.`%s` r JOIN `%s`.`%s` c ON c.`%s` = r.`%s`;",
+				sampleSchema, rel.ReferencedTable, targetSchema, rel.ReferencedTable, sampleSchema, rel.Table, rel.Column, rel.ReferencedColumn)
+			res, err := conn.ExecContext(ctx, q)
+			if err != nil {
+				return fmt.Errorf("insert failed: %w query %s", err, q)
+			}
+			n, err := res.RowsAffected()
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				log.Printf("insert %s\n", q)
+			}
+			inserted += n
+		}
+		if inserted == 0 {
+			return nil
+		}
+	}
+}
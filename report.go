@@ -0,0 +1,226 @@
+package sampledb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Report holds the statistics of a sampling run
+type Report struct {
+	mu          sync.Mutex
+	Tables      map[string]*TableReport `json:"tables"`
+	Edges       []*EdgeReport           `json:"edges"`
+	LimitsHit   []string                `json:"limits_hit"`
+	EmptyTables []string                `json:"empty_tables"`
+	Phases      []PhaseReport           `json:"phases"`
+	edges       map[string]*EdgeReport
+}
+
+// TableReport holds the statistics of a sample table
+type TableReport struct {
+	// rows inserted by this run and the size of their column data
+	RowsCopied int64 `json:"rows_copied"`
+	Bytes      int64 `json:"bytes"`
+	// rows in the sample table once the run is done, including the ones copied in full or by previous runs
+	SampleRows int64 `json:"sample_rows"`
+	// rows in the target table as estimated by the engine
+	SourceRows int64 `json:"source_rows"`
+}
+
+// EdgeReport holds the statistics of a relationship followed while sampling
+type EdgeReport struct {
+	Table            string `json:"table"`
+	Column           string `json:"column"`
+	ReferencedTable  string `json:"referenced_table"`
+	ReferencedColumn string `json:"referenced_column"`
+	// foward edges go from the table to the referenced table, reverse ones the other way around
+	Direction string `json:"direction"`
+	// key values we followed the edge from and the rows they led us to
+	Keys   int64   `json:"keys"`
+	Rows   int64   `json:"rows"`
+	FanOut float64 `json:"fan_out"`
+	// rows the edge led to that were left out to keep the sample within its budget
+	Dropped int64 `json:"dropped"`
+}
+
+// PhaseReport is a step of the run and how long it took
+type PhaseReport struct {
+	Name    string  `json:"name"`
+	Seconds float64 `json:"seconds"`
+}
+
+func newReport() *Report {
+	return &Report{
+		Tables:      map[string]*TableReport{},
+		Edges:       []*EdgeReport{},
+		LimitsHit:   []string{},
+		EmptyTables: []string{},
+		Phases:      []PhaseReport{},
+		edges:       map[string]*EdgeReport{},
+	}
+}
+
+func (r *Report) table(name string) *TableReport {
+	t, ok := r.Tables[name]
+	if !ok {
+		t = &TableReport{}
+		r.Tables[name] = t
+	}
+	return t
+}
+
+func (r *Report) copied(table string, rows, bytes int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t := r.table(table)
+	t.RowsCopied += rows
+	t.Bytes += bytes
+}
+
+func (r *Report) edge(rel ForeignKey, direction string) *EdgeReport {
+	id := fmt.Sprintf("%s.%s %s %s.%s", rel.Table, rel.Column, direction, rel.ReferencedTable, rel.ReferencedColumn)
+	e, ok := r.edges[id]
+	if !ok {
+		e = &EdgeReport{
+			Table: rel.Table, Column: rel.Column,
+			ReferencedTable: rel.ReferencedTable, ReferencedColumn: rel.ReferencedColumn,
+			Direction: direction,
+		}
+		r.edges[id] = e
+		r.Edges = append(r.Edges, e)
+	}
+	return e
+}
+
+// followed records the rows we got to from keys through the relationship
+func (r *Report) followed(rel ForeignKey, direction string, keys, rows int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e := r.edge(rel, direction)
+	e.Keys += int64(keys)
+	e.Rows += int64(rows)
+	e.FanOut = float64(e.Rows) / float64(e.Keys)
+}
+
+// dropped records the rows of a reverse relationship left out of the sample
+func (r *Report) dropped(rel ForeignKey, rows int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.edge(rel, "reverse").Dropped += int64(rows)
+}
+
+func (r *Report) limitHit(format string, args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.LimitsHit = append(r.LimitsHit, fmt.Sprintf(format, args...))
+}
+
+// phase starts timing a phase of the run, the returned func ends it
+func (r *Report) phase(name string) func() {
+	start := time.Now()
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.Phases = append(r.Phases, PhaseReport{Name: name, Seconds: time.Since(start).Seconds()})
+	}
+}
+
+// finish fills in the row counts of the source and sample tables once we're done copying rows, the sample
+// is only counted when its rows can be read back
+func (r *Report) finish(ctx context.Context, source RowReader, dest RowWriter) error {
+	store, ok := dest.(SampleStore)
+	if !ok {
+		return nil
+	}
+	tables, err := store.Tables(ctx)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.EmptyTables = []string{}
+	for _, table := range tables {
+		t := r.table(table)
+		t.SourceRows, err = source.CountRows(ctx, table)
+		if err != nil {
+			return err
+		}
+		t.SampleRows, err = store.CountRows(ctx, table)
+		if err != nil {
+			return err
+		}
+		if t.SampleRows == 0 {
+			r.EmptyTables = append(r.EmptyTables, table)
+		}
+	}
+	return nil
+}
+
+func (r *Report) WriteJSON(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// Print writes the report as human readable tables
+func (r *Report) Print(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "TABLE\tCOPIED\tBYTES\tIN SAMPLE\tIN SOURCE (EST.)")
+	tableNames := []string{}
+	for table := range r.Tables {
+		tableNames = append(tableNames, table)
+	}
+	sort.Strings(tableNames)
+	for _, table := range tableNames {
+		t := r.Tables[table]
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\n", table, t.RowsCopied, t.Bytes, t.SampleRows, t.SourceRows)
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "EDGE\tDIRECTION\tKEYS\tROWS\tFAN-OUT\tDROPPED")
+	for _, e := range r.Edges {
+		fmt.Fprintf(tw, "%s.%s -> %s.%s\t%s\t%d\t%d\t%.2f\t%d\n", e.Table, e.Column, e.ReferencedTable, e.ReferencedColumn, e.Direction, e.Keys, e.Rows, e.FanOut, e.Dropped)
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "PHASE\tSECONDS")
+	for _, p := range r.Phases {
+		fmt.Fprintf(tw, "%s\t%.3f\n", p.Name, p.Seconds)
+	}
+	for _, limit := range r.LimitsHit {
+		fmt.Fprintf(tw, "\nlimit hit: %s", limit)
+	}
+	if len(r.EmptyTables) > 0 {
+		fmt.Fprintf(tw, "\nempty tables: %v", r.EmptyTables)
+	}
+	fmt.Fprintln(tw)
+	return tw.Flush()
+}
+
+// returns the size of the row column data
+func rowSize(rowData map[string]interface{}) int64 {
+	var size int64
+	for _, columnData := range rowData {
+		switch v := columnData.(type) {
+		case nil:
+		case []byte:
+			size += int64(len(v))
+		case string:
+			size += int64(len(v))
+		default:
+			size += 8
+		}
+	}
+	return size
+}
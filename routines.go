@@ -0,0 +1,223 @@
+package sampledb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	definerRE = regexp.MustCompile("\\sDEFINER\\s*=\\s*(?:`[^`]*`|'[^']*'|\\w+)(?:@(?:`[^`]*`|'[^']*'|[\\w.%-]+))?")
+)
+
+// a stored routine, trigger or event and the statement that creates it
+type schemaObject struct {
+	kind    string
+	name    string
+	create  string
+	sqlMode string
+}
+
+// perms: requires SHOW_ROUTINE (or SELECT on mysql.proc) for routines
+func copyRoutines(ctx context.Context, db *sql.DB, targetSchema, sampleSchema string) error {
+	return copyObjects(ctx, db, targetSchema, sampleSchema,
+		"SELECT routine_type, routine_name FROM information_schema.routines WHERE routine_schema = ? ORDER BY routine_type DESC, routine_name;")
+}
+
+// CopyEvents copies the events of the target schema to the sample schema. Like CopyTriggers it should run
+// once the sample data has been copied, so scheduled events don't change rows while sampling.
+// perms: requires EVENT privilege
+func CopyEvents(ctx context.Context, db *sql.DB, targetSchema, sampleSchema string) error {
+	return copyObjects(ctx, db, targetSchema, sampleSchema,
+		"SELECT 'EVENT', event_name FROM information_schema.events WHERE event_schema = ? ORDER BY event_name;")
+}
+
+// CopyTriggers copies the triggers of the target schema to the sample schema. It should run once the sample
+// data has been copied, so triggers don't fire while sampling. Triggers of tables the sample schema doesn't
+// have are left out.
+// perms: requires TRIGGER privilege
+func CopyTriggers(ctx context.Context, db *sql.DB, targetSchema, sampleSchema string) error {
+	// triggers for the same table and event are created in the order they fire
+	return copyObjects(ctx, db, targetSchema, sampleSchema,
+		"SELECT 'TRIGGER', trigger_name FROM information_schema.triggers WHERE trigger_schema = ?"+
+			" AND event_object_table IN (SELECT table_name FROM information_schema.tables WHERE table_schema = ?)"+
+			" ORDER BY event_object_table, action_order;", sampleSchema)
+}
+
+// drops the triggers of the schema so they don't fire while rows are copied to it, CopyTriggers creates them
+// again once the rows are in
+func dropTriggers(ctx context.Context, db *sql.DB, schema string) error {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SHOW TRIGGERS FROM `%s`;", schema))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	// the trigger name comes first
+	triggers := []string{}
+	vals := make([]sql.RawBytes, len(cols))
+	ptrs := make([]interface{}, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	for rows.Next() {
+		err = rows.Scan(ptrs...)
+		if err != nil {
+			return err
+		}
+		triggers = append(triggers, string(vals[0]))
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	for _, trigger := range triggers {
+		_, err = db.ExecContext(ctx, fmt.Sprintf("DROP TRIGGER IF EXISTS `%s`.`%s`;", schema, trigger))
+		if err != nil {
+			return fmt.Errorf("drop trigger %s: %w", trigger, err)
+		}
+	}
+	return nil
+}
+
+// copyObjects creates the objects listed by the (kind, name) query in the sample schema unless they exist
+// there already. The query is given the schema and then args. Their definer is dropped and references to
+// the target schema point to the sample schema.
+func copyObjects(ctx context.Context, db *sql.DB, targetSchema, sampleSchema, listQuery string, args ...interface{}) error {
+	existing, err := listObjects(ctx, db, sampleSchema, listQuery, args...)
+	if err != nil {
+		return err
+	}
+	exists := map[string]struct{}{}
+	for _, obj := range existing {
+		exists[obj.kind+" "+obj.name] = struct{}{}
+	}
+	objects, err := listObjects(ctx, db, targetSchema, listQuery, args...)
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// unqualified names in the statements resolve to the default schema
+	_, err = conn.ExecContext(ctx, fmt.Sprintf("USE `%s`;", sampleSchema))
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		if _, ok := exists[obj.kind+" "+obj.name]; ok {
+			continue
+		}
+		err = showCreate(ctx, db, targetSchema, &obj)
+		if err != nil {
+			return err
+		}
+		stmt := rewriteSchemaRefs(stripDefiner(obj.create), targetSchema, sampleSchema)
+		err = execWithSQLMode(ctx, conn, obj.sqlMode, stmt)
+		if err != nil {
+			return fmt.Errorf("create %s %s: %w", strings.ToLower(obj.kind), obj.name, err)
+		}
+	}
+	return nil
+}
+
+func listObjects(ctx context.Context, db *sql.DB, schema, listQuery string, args ...interface{}) ([]schemaObject, error) {
+	rows, err := db.QueryContext(ctx, listQuery, append([]interface{}{schema}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	objects := []schemaObject{}
+	for rows.Next() {
+		var obj schemaObject
+		err = rows.Scan(&obj.kind, &obj.name)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, obj)
+	}
+	return objects, rows.Err()
+}
+
+// showCreate fills in the create statement of the object and the sql_mode it was created with
+func showCreate(ctx context.Context, db *sql.DB, schema string, obj *schemaObject) error {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SHOW CREATE %s `%s`.`%s`;", obj.kind, schema, obj.name))
+	if err != nil {
+		return fmt.Errorf("show create %s %s: %w", strings.ToLower(obj.kind), obj.name, err)
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	if !rows.Next() {
+		return fmt.Errorf("could not find %s %s", strings.ToLower(obj.kind), obj.name)
+	}
+	vals := make([]sql.NullString, len(cols))
+	ptrs := make([]interface{}, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	err = rows.Scan(ptrs...)
+	if err != nil {
+		return err
+	}
+	for i, col := range cols {
+		switch {
+		case col == "sql_mode":
+			obj.sqlMode = vals[i].String
+		case strings.HasPrefix(col, "Create ") || col == "SQL Original Statement":
+			obj.create = vals[i].String
+		}
+	}
+	if obj.create == "" {
+		return fmt.Errorf("no permission to read the definition of %s %s", strings.ToLower(obj.kind), obj.name)
+	}
+	return nil
+}
+
+// runs the statement with the session sql_mode set to sqlMode, routines keep the sql_mode they're created with
+func execWithSQLMode(ctx context.Context, conn *sql.Conn, sqlMode, stmt string) error {
+	_, err := conn.ExecContext(ctx, "SET @sampledb_sql_mode = @@session.sql_mode;")
+	if err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx, "SET SESSION sql_mode = ?;", sqlMode)
+	if err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx, stmt)
+	_, rerr := conn.ExecContext(ctx, "SET SESSION sql_mode = @sampledb_sql_mode;")
+	if err != nil {
+		return err
+	}
+	return rerr
+}
+
+// removes the DEFINER clause so objects are created by the current user, who may not be allowed to set it
+func stripDefiner(stmt string) string {
+	return definerRE.ReplaceAllString(stmt, "")
+}
+
+// points schema qualified references to objects in schema from to schema to, string literals are left as
+// they are
+func rewriteSchemaRefs(stmt, from, to string) string {
+	re := regexp.MustCompile("(^|[^\\w$.`])(`" + regexp.QuoteMeta(from) + "`|" + regexp.QuoteMeta(from) + ")\\.")
+	repl := "${1}`" + strings.ReplaceAll(to, "$", "$$") + "`."
+	rewritten, last := "", 0
+	for _, loc := range quotedRE.FindAllStringIndex(stmt, -1) {
+		if stmt[loc[0]] == '`' {
+			continue
+		}
+		rewritten += re.ReplaceAllString(stmt[last:loc[0]], repl) + stmt[loc[0]:loc[1]]
+		last = loc[1]
+	}
+	return rewritten + re.ReplaceAllString(stmt[last:], repl)
+}
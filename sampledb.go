@@ -0,0 +1,336 @@
+// Package sampledb copies a referentially complete sample of a MySQL schema into another schema. Starting
+// from a few anchor rows it follows foreign keys both ways: rows referenced by the sampled rows are always
+// copied, rows referencing them are sampled in turn.
+package sampledb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var (
+	anchorParamsRE = regexp.MustCompile(`^(?P<table>\w+)(?:#(?P<column>\w+)=(?P<values>(?:\w+[,]?)+)*|$)`)
+)
+
+// Options of a sampling run
+type Options struct {
+	// schema the rows are sampled from
+	TargetSchema string
+	// schema the rows are copied to, an unused sample_db_{secs since January 1, 1970 UTC}_{random suffix}
+	// one is picked when empty
+	SampleSchema string
+	// rows the sample starts from
+	Anchor Anchor
+	// tables copied in full, names or patterns matched against the target tables: globs like lookup_* or
+	// regular expressions between slashes like /^(ref|lookup)_/. every pattern has to match a table
+	NoSample []string
+	// narrow down the rows copied of NoSample tables, by table
+	NoSampleFilters map[string]TableFilter
+	// number of concurrent workers used to follow relationships and copy NoSample tables, defaults to 1.
+	// relationships are followed by a single worker when MaxRows or MaxBytes is set
+	Workers int
+	// rows of NoSample tables copied by each statement, defaults to 10000
+	ChunkRows int
+	// called as NoSample tables are copied, see CopyOptions
+	Progress func(table string, copied, estimated int64)
+	// what to do when SampleSchema exists already, one of the IfExists modes, defaults to IfExistsFail
+	IfExists string
+	// file where progress is saved so an interrupted run can be resumed, checkpoints are disabled if empty
+	Checkpoint string
+	// resume the interrupted run saved in Checkpoint, the schemas and anchor are read from it
+	Resume bool
+	// picks the same random anchor rows on every run of the same data when non zero, rows are read in
+	// primary key order either way so the same anchor rows always give the same sample
+	Seed int64
+	// most rows and bytes of column data the sample copies, zero is no cap. Once they're reached the rows
+	// reverse relationships lead to are left out, the rows copied rows reference are copied anyway so a
+	// sample can go over. Rows of NoSample tables and rows that were in the sample already don't count, a
+	// resumed run goes on with what the interrupted one had left.
+	MaxRows  int64
+	MaxBytes int64
+	// rows copied instead of sampling from Anchor, they're copied along with the rows they reference and
+	// nothing else. TargetSchema defaults to the one of the manifest
+	Replay *SampleManifest
+	// file the primary keys of the anchor rows and of the sampled rows are written to, see SampleManifest. not
+	// written if empty. With IfExistsReuse the manifest a previous run wrote there is read first, the rows it
+	// sampled aren't walked again and its keys are kept
+	Manifest string
+	// rows are read from Source instead of TargetSchema when it's set, the schema is still copied from
+	// TargetSchema unless Dest is set too
+	Source RowSource
+	// rows are written to Dest instead of SampleSchema when it's set. Only the sampled rows are: there's no
+	// schema to copy, no NoSample tables and no triggers. IfExistsReuse keeps the rows Dest holds when it's a
+	// SampleStore, the other IfExists modes don't apply
+	Dest RowWriter
+}
+
+// returns the schema rows are sampled from
+func (opts Options) source(db *sql.DB) RowSource {
+	if opts.Source != nil {
+		return opts.Source
+	}
+	return newMysqlSchema(db, opts.TargetSchema)
+}
+
+// Anchor is the rows a sample starts from: the rows of Table whose Column is one of Values, or randomly
+// picked rows of Table when there are no Values
+type Anchor struct {
+	Table  string
+	Column string
+	Values []string
+}
+
+// ParseAnchor parses the table#column=value,value anchor format, table alone picks random rows
+func ParseAnchor(anchor string) (Anchor, error) {
+	if !anchorParamsRE.MatchString(anchor) {
+		return Anchor{}, fmt.Errorf("bad format for anchor table params %q", anchor)
+	}
+	res := anchorParamsRE.FindAllStringSubmatch(anchor, -1)
+	data := make(map[string]string)
+	sxp := anchorParamsRE.SubexpNames()
+	for i, mm := range res[0] {
+		if i == 0 {
+			continue
+		}
+		data[sxp[i]] = mm
+	}
+	a := Anchor{Table: data["table"]}
+	for _, val := range strings.Split(data["values"], ",") {
+		if val != "" {
+			a.Values = append(a.Values, val)
+		}
+	}
+	if len(a.Values) > 0 {
+		a.Column = data["column"]
+	}
+	return a, nil
+}
+
+func (a Anchor) params() *sampleParams {
+	if len(a.Values) == 0 {
+		return &sampleParams{table: a.Table, rand: true}
+	}
+	params := &sampleParams{table: a.Table, column: a.Column}
+	for _, val := range a.Values {
+		params.data = append(params.data, val)
+	}
+	return params
+}
+
+// Result of a sampling run
+type Result struct {
+	TargetSchema string
+	SampleSchema string
+	Report       *Report
+	// the anchor rows the sample started from
+	Manifest *SampleManifest
+}
+
+// NewSampler returns a Sampler that samples db with opts, a Sampler is good for a single run
+func NewSampler(db *sql.DB, opts Options) *Sampler {
+	workers := opts.Workers
+	if opts.MaxRows > 0 || opts.MaxBytes > 0 {
+		// the budget goes to the rows written first, concurrent workers would write them in any order
+		workers = 1
+	}
+	s := newSampler(db, opts.TargetSchema, opts.SampleSchema, workers)
+	s.opts = opts
+	s.setSchemas(opts.TargetSchema, opts.SampleSchema)
+	s.budget.maxRows, s.budget.maxBytes = opts.MaxRows, opts.MaxBytes
+	if s.opts.IfExists == "" {
+		s.opts.IfExists = IfExistsFail
+	}
+	return s
+}
+
+// Sample samples db into a sample schema, see Options
+func Sample(ctx context.Context, db *sql.DB, opts Options) (*Result, error) {
+	return NewSampler(db, opts).Sample(ctx)
+}
+
+// Sample copies the schema, samples the rows and copies the triggers once they're in. When the run fails
+// the sample schema is dropped if the run created it, unless it's checkpointed so the run can be resumed.
+func (s *Sampler) Sample(ctx context.Context) (*Result, error) {
+	if s.opts.Resume && s.opts.Checkpoint == "" {
+		return nil, fmt.Errorf("resuming requires a checkpoint file")
+	}
+	if !validIfExists(s.opts.IfExists) {
+		return nil, fmt.Errorf("unknown if exists mode %s, must be one of fail, drop, reuse or truncate", s.opts.IfExists)
+	}
+	if s.opts.Replay != nil {
+		if s.opts.Checkpoint != "" {
+			return nil, fmt.Errorf("replayed samples aren't checkpointed, replay them again instead")
+		}
+		if s.targetSchema == "" {
+			s.setSchemas(s.opts.Replay.TargetSchema, s.sampleSchema)
+		}
+	}
+
+	if s.opts.Dest != nil && len(s.opts.NoSample) > 0 {
+		return nil, fmt.Errorf("tables copied in full need a sample schema, they can't be written to Dest")
+	}
+
+	var params *sampleParams
+	var created bool
+	if s.opts.Resume {
+		cp, err := readCheckpoint(s.opts.Checkpoint)
+		if err != nil {
+			return nil, fmt.Errorf("read checkpoint: %w", err)
+		}
+		s.setSchemas(cp.TargetSchema, cp.SampleSchema)
+		s.opts.Seed = cp.Seed
+		params = s.restore(cp)
+		if s.opts.Dest == nil {
+			// the run may have been interrupted while copying the triggers
+			err = dropTriggers(ctx, s.db.DB, s.sampleSchema)
+			if err != nil {
+				return nil, fmt.Errorf("drop sample triggers: %w", err)
+			}
+		}
+	} else {
+		params = s.opts.Anchor.params()
+		reuse := s.opts.IfExists == IfExistsReuse
+		if s.opts.Dest == nil {
+			var exists bool
+			var err error
+			created, exists, err = s.createSample(ctx)
+			if err != nil {
+				return nil, err
+			}
+			reuse = reuse && exists
+		}
+		if reuse {
+			phaseDone := s.report.phase("read existing sample")
+			err := s.readAppended()
+			if err != nil {
+				return nil, s.cleanup(created, fmt.Errorf("read sample manifest: %w", err))
+			}
+			err = s.seedVisits(ctx)
+			if err != nil {
+				return nil, s.cleanup(created, fmt.Errorf("read existing sample: %w", err))
+			}
+			phaseDone()
+		}
+	}
+
+	s.checkpointPath = s.opts.Checkpoint
+	phaseDone := s.report.phase("sample")
+	var err error
+	if s.opts.Replay != nil {
+		err = s.replay(ctx, s.opts.Replay)
+	} else {
+		err = s.run(ctx, params)
+	}
+	if err != nil {
+		return nil, s.cleanup(created, fmt.Errorf("sample db: %w", err))
+	}
+	phaseDone()
+	if s.budget.over() {
+		rows, bytes := s.budget.spent()
+		s.report.limitHit("budget: copied %d rows and %d bytes, over the budget of %s as the rows the sample references are always copied",
+			rows, bytes, s.budget)
+	}
+
+	if s.opts.Dest == nil {
+		// triggers would have fired while we copied rows
+		phaseDone = s.report.phase("copy triggers")
+		err = CopyTriggers(ctx, s.db.DB, s.targetSchema, s.sampleSchema)
+		if err != nil {
+			return nil, s.cleanup(created, fmt.Errorf("copy triggers: %w", err))
+		}
+		phaseDone()
+		// and events would have run
+		phaseDone = s.report.phase("copy events")
+		err = CopyEvents(ctx, s.db.DB, s.targetSchema, s.sampleSchema)
+		if err != nil {
+			return nil, s.cleanup(created, fmt.Errorf("copy events: %w", err))
+		}
+		phaseDone()
+	}
+
+	if s.appended != nil {
+		s.manifest.merge(s.appended)
+	}
+	if s.opts.Manifest != "" {
+		err = s.manifest.Write(s.opts.Manifest)
+		if err != nil {
+			return nil, fmt.Errorf("write manifest: %w", err)
+		}
+	}
+	err = s.report.finish(ctx, s.source, s.dest)
+	if err != nil {
+		return nil, fmt.Errorf("count sampled rows: %w", err)
+	}
+	return &Result{TargetSchema: s.targetSchema, SampleSchema: s.sampleSchema, Report: s.report, Manifest: s.manifest}, nil
+}
+
+// readAppended reads the manifest of the sample we're appending to, there's none if the previous run didn't
+// write one
+func (s *Sampler) readAppended() error {
+	if s.opts.Manifest == "" {
+		return nil
+	}
+	m, err := ReadSampleManifest(s.opts.Manifest)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	s.appended = m
+	return err
+}
+
+// createSample picks the sample schema name if there's none and copies the target schema to it. It returns
+// whether the sample schema was created by the run and whether it existed already.
+func (s *Sampler) createSample(ctx context.Context) (bool, bool, error) {
+	if s.sampleSchema == "" {
+		name, err := uniqueSchemaName(ctx, s.db.DB)
+		if err != nil {
+			return false, false, fmt.Errorf("pick a sample schema name: %w", err)
+		}
+		s.setSchemas(s.targetSchema, name)
+	}
+	noSample, err := resolveTables(ctx, s.db.DB, s.targetSchema, s.opts.NoSample)
+	if err != nil {
+		return false, false, fmt.Errorf("tables copied in full: %w", err)
+	}
+	noSmplTbls := map[string]struct{}{}
+	for _, tbl := range noSample {
+		noSmplTbls[tbl] = struct{}{}
+	}
+	for tbl := range s.opts.NoSampleFilters {
+		if _, ok := noSmplTbls[tbl]; !ok {
+			return false, false, fmt.Errorf("filtered table %s isn't copied in full", tbl)
+		}
+	}
+
+	exists, err := schemaExists(ctx, s.db.DB, s.sampleSchema)
+	if err != nil {
+		return false, false, fmt.Errorf("look up sample schema: %w", err)
+	}
+	created := !exists || s.opts.IfExists == IfExistsDrop
+
+	phaseDone := s.report.phase("copy schema")
+	copyOpts := CopyOptions{ChunkRows: s.opts.ChunkRows, Workers: s.opts.Workers, Progress: s.opts.Progress, Filters: s.opts.NoSampleFilters}
+	err = CopySchemaWithOptions(ctx, s.db.DB, s.targetSchema, s.sampleSchema, noSmplTbls, s.opts.IfExists, copyOpts)
+	if err != nil {
+		return false, false, s.cleanup(created, fmt.Errorf("copy schema: %w", err))
+	}
+	phaseDone()
+	return created, exists, nil
+}
+
+// cleanup drops the sample of a failed run if the run created it and it's not checkpointed
+func (s *Sampler) cleanup(created bool, err error) error {
+	if store, ok := s.dest.(SampleStore); ok && created && s.opts.Checkpoint == "" {
+		dropErr := store.Drop(context.Background())
+		if dropErr != nil {
+			log.Printf("could not drop sample schema %s: %s", s.sampleSchema, dropErr)
+		}
+	}
+	return err
+}
@@ -0,0 +1,307 @@
+// Package sampledbtest is an in memory schema that can be sampled from and written to, so code built on
+// sampledb can be tested without a db server. Schemas are loaded from sql fixtures, see LoadSQL.
+package sampledbtest
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+
+	"github.com/shopsoko/sampledb"
+)
+
+// an in memory table, rows are kept in insertion order and column values as the strings the mysql driver
+// would hand us
+type table struct {
+	columns []string
+	pk      []string
+	fks     []sampledb.ForeignKey
+	rows    []map[string]interface{}
+}
+
+// Schema is an in memory schema, it's both a sampledb.RowSource and a sampledb.SampleStore
+type Schema struct {
+	mu     sync.Mutex
+	tables map[string]*table
+}
+
+// NewSchema returns a schema without tables
+func NewSchema() *Schema {
+	return &Schema{tables: map[string]*table{}}
+}
+
+// Empty returns a schema with the same tables and no rows
+func (m *Schema) Empty() *Schema {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cp := NewSchema()
+	for name, t := range m.tables {
+		cp.tables[name] = &table{columns: t.columns, pk: t.pk, fks: t.fks}
+	}
+	return cp
+}
+
+func (m *Schema) table(name string) (*table, error) {
+	t, ok := m.tables[name]
+	if !ok {
+		return nil, fmt.Errorf("table %s doesn't exist", name)
+	}
+	return t, nil
+}
+
+func (m *Schema) Tables(ctx context.Context) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.tableNames(), nil
+}
+
+func (m *Schema) tableNames() []string {
+	names := []string{}
+	for name := range m.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (m *Schema) PrimaryKey(ctx context.Context, table string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// like information_schema a table we don't know about has no key
+	cols := []string{}
+	if t, ok := m.tables[table]; ok {
+		cols = append(cols, t.pk...)
+	}
+	return cols, nil
+}
+
+func (m *Schema) FowardRelationships(ctx context.Context, table string) ([]sampledb.ForeignKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rels := []sampledb.ForeignKey{}
+	if t, ok := m.tables[table]; ok {
+		rels = append(rels, t.fks...)
+	}
+	return rels, nil
+}
+
+func (m *Schema) ReverseRelationships(ctx context.Context, table string) ([]sampledb.ForeignKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rels := []sampledb.ForeignKey{}
+	for _, name := range m.tableNames() {
+		for _, rel := range m.tables[name].fks {
+			if rel.ReferencedTable == table {
+				rels = append(rels, rel)
+			}
+		}
+	}
+	return rels, nil
+}
+
+func (m *Schema) ReadRows(ctx context.Context, table string, columns []string, keys [][]interface{}) ([]map[string]interface{}, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, err := m.table(table)
+	if err != nil {
+		return nil, err
+	}
+	wanted := map[string]struct{}{}
+	for _, key := range keys {
+		wanted[keyOf(columns, key)] = struct{}{}
+	}
+	datas := []map[string]interface{}{}
+	for _, row := range t.rows {
+		if _, ok := wanted[keyOf(columns, rowValues(row, columns))]; ok {
+			datas = append(datas, copyRow(row))
+		}
+	}
+	return datas, nil
+}
+
+// picking rows at random would make tests flaky, without a seed the first n rows are picked and with one the
+// rows are shuffled by a hash of the seed and their primary key
+func (m *Schema) RandomRows(ctx context.Context, table string, n int, seed int64) ([]map[string]interface{}, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, err := m.table(table)
+	if err != nil {
+		return nil, err
+	}
+	rows := append([]map[string]interface{}{}, t.rows...)
+	if seed != 0 {
+		hashes := make([]uint32, len(rows))
+		for i, row := range rows {
+			h := fnv.New32a()
+			fmt.Fprint(h, seed)
+			for _, col := range t.pk {
+				fmt.Fprintf(h, ",%v", row[col])
+			}
+			hashes[i] = h.Sum32()
+		}
+		sort.Sort(rowsByHash{rows, hashes})
+	}
+	datas := []map[string]interface{}{}
+	for i := 0; i < n && i < len(rows); i++ {
+		datas = append(datas, copyRow(rows[i]))
+	}
+	return datas, nil
+}
+
+type rowsByHash struct {
+	rows   []map[string]interface{}
+	hashes []uint32
+}
+
+func (b rowsByHash) Len() int           { return len(b.rows) }
+func (b rowsByHash) Less(i, j int) bool { return b.hashes[i] < b.hashes[j] }
+func (b rowsByHash) Swap(i, j int) {
+	b.rows[i], b.rows[j] = b.rows[j], b.rows[i]
+	b.hashes[i], b.hashes[j] = b.hashes[j], b.hashes[i]
+}
+
+func (m *Schema) CountRows(ctx context.Context, table string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, err := m.table(table)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(t.rows)), nil
+}
+
+func (m *Schema) WriteRows(ctx context.Context, table string, rows []map[string]interface{}) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, err := m.table(table)
+	if err != nil {
+		return 0, err
+	}
+	// like INSERT IGNORE rows with a key we have already are skipped
+	keyCols := t.pk
+	if len(keyCols) == 0 {
+		keyCols = t.columns
+	}
+	existing := map[string]struct{}{}
+	for _, row := range t.rows {
+		existing[keyOf(keyCols, rowValues(row, keyCols))] = struct{}{}
+	}
+	var written int64
+	for _, row := range rows {
+		key := keyOf(keyCols, rowValues(row, keyCols))
+		if _, exists := existing[key]; exists {
+			continue
+		}
+		existing[key] = struct{}{}
+		t.rows = append(t.rows, copyRow(row))
+		written++
+	}
+	return written, nil
+}
+
+func (m *Schema) ReadColumns(ctx context.Context, table string, columns []string) ([][]interface{}, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, err := m.table(table)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]struct{}{}
+	vals := [][]interface{}{}
+	for _, row := range t.rows {
+		val := rowValues(row, columns)
+		if key := keyOf(columns, val); !contains(seen, key) {
+			seen[key] = struct{}{}
+			vals = append(vals, val)
+		}
+	}
+	return vals, nil
+}
+
+// Drop removes the tables of the schema
+func (m *Schema) Drop(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tables = map[string]*table{}
+	return nil
+}
+
+// Keys returns the primary keys of the rows of the table, formatted as col=val,col=val and sorted
+func (m *Schema) Keys(table string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := []string{}
+	if t, ok := m.tables[table]; ok {
+		for _, row := range t.rows {
+			keys = append(keys, keyOf(t.pk, rowValues(row, t.pk)))
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Orphans returns the foreign key values of the rows that reference missing rows, formatted as
+// table.col=val
+func (m *Schema) Orphans() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	orphans := []string{}
+	for _, name := range m.tableNames() {
+		for _, rel := range m.tables[name].fks {
+			referenced := map[string]struct{}{}
+			if t, ok := m.tables[rel.ReferencedTable]; ok {
+				for _, row := range t.rows {
+					referenced[fmt.Sprint(row[rel.ReferencedColumn])] = struct{}{}
+				}
+			}
+			for _, row := range m.tables[name].rows {
+				if row[rel.Column] == nil {
+					continue
+				}
+				if !contains(referenced, fmt.Sprint(row[rel.Column])) {
+					orphans = append(orphans, fmt.Sprintf("%s.%s=%v", name, rel.Column, row[rel.Column]))
+				}
+			}
+		}
+	}
+	return orphans
+}
+
+// returns the key of the column values the way sampledb formats them
+func keyOf(columns []string, vals []interface{}) string {
+	var key string
+	for i, col := range columns {
+		val := vals[i]
+		if b, ok := val.([]byte); ok {
+			val = string(b)
+		}
+		if i > 0 {
+			key += ","
+		}
+		key += fmt.Sprintf("%s=%v", col, val)
+	}
+	return key
+}
+
+func contains(set map[string]struct{}, key string) bool {
+	_, ok := set[key]
+	return ok
+}
+
+func rowValues(row map[string]interface{}, columns []string) []interface{} {
+	vals := make([]interface{}, len(columns))
+	for i, col := range columns {
+		vals[i] = row[col]
+	}
+	return vals
+}
+
+func copyRow(row map[string]interface{}) map[string]interface{} {
+	cp := make(map[string]interface{}, len(row))
+	for col, val := range row {
+		cp[col] = val
+	}
+	return cp
+}
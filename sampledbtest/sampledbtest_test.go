@@ -0,0 +1,86 @@
+package sampledbtest
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/shopsoko/sampledb"
+)
+
+func TestLoad(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("..", "test-fixtures", "*.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		_, err := Load(path)
+		if err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+	}
+
+	schemas, err := Load(filepath.Join("..", "test-fixtures", "sample.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sample := schemas["sample"]
+	if sample == nil {
+		t.Fatal("expected the sample schema to be loaded")
+	}
+	for table, expected := range map[string]int{"departments": 2, "dept_emp": 3, "employees": 3} {
+		if n := len(sample.Keys(table)); n != expected {
+			t.Fatalf("expected %d rows in %s, got %d", expected, table, n)
+		}
+	}
+	pk, err := sample.PrimaryKey(context.TODO(), "dept_emp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pk, []string{"emp_no", "dept_no"}) {
+		t.Fatalf("unexpected dept_emp primary key %v", pk)
+	}
+	rels, err := sample.ReverseRelationships(context.TODO(), "departments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []sampledb.ForeignKey{{Table: "dept_emp", Column: "dept_no", ReferencedTable: "departments", ReferencedColumn: "dept_no"}}
+	if !reflect.DeepEqual(rels, expected) {
+		t.Fatalf("expected %v, got %v", expected, rels)
+	}
+	rows, err := sample.ReadRows(context.TODO(), "employees", []string{"emp_no"}, [][]interface{}{{"10001"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0]["first_name"] != "Georgi" {
+		t.Fatalf("unexpected rows %v", rows)
+	}
+	rows, err = sample.ReadRows(context.TODO(), "dept_emp", []string{"emp_no", "dept_no"}, [][]interface{}{{"10003", "d002"}, {"10001", "d002"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0]["emp_no"] != "10003" {
+		t.Fatalf("unexpected rows %v", rows)
+	}
+}
+
+func TestLoadSQL(t *testing.T) {
+	schemas, err := LoadSQL(`CREATE DATABASE quoted; use quoted;
+CREATE TABLE ` + "`notes`" + ` (id INT NOT NULL, body TEXT, price DECIMAL(10,2), PRIMARY KEY (id));
+INSERT INTO quoted.notes VALUES (1, 'it''s; here', 10.50), (2, 'back\\slash \'q\'', NULL);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := schemas["quoted"].ReadRows(context.TODO(), "notes", []string{"id"}, [][]interface{}{{"1"}, {"2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []map[string]interface{}{
+		{"id": "1", "body": "it's; here", "price": "10.50"},
+		{"id": "2", "body": `back\slash 'q'`, "price": nil},
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Fatalf("expected %v, got %v", expected, rows)
+	}
+}
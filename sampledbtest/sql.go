@@ -0,0 +1,255 @@
+package sampledbtest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"unicode"
+
+	"github.com/shopsoko/sampledb"
+)
+
+// Load loads the schemas created by a sql fixture file, see LoadSQL
+func Load(path string) (map[string]*Schema, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return LoadSQL(string(data))
+}
+
+// LoadSQL runs the handful of statements fixtures are made of and returns the schemas they create by name:
+// CREATE and DROP DATABASE, USE, CREATE TABLE with its primary and foreign keys and INSERT ... VALUES. Other
+// statements are skipped.
+func LoadSQL(script string) (map[string]*Schema, error) {
+	schemas := map[string]*Schema{}
+	var current string
+	for _, toks := range statements(tokenize(script)) {
+		kw := strings.ToUpper(toks[0])
+		if len(toks) > 1 {
+			kw += " " + strings.ToUpper(toks[1])
+		}
+		switch {
+		case kw == "DROP DATABASE":
+			delete(schemas, unquote(toks[len(toks)-1]))
+		case kw == "CREATE DATABASE":
+			if name := unquote(toks[len(toks)-1]); schemas[name] == nil {
+				schemas[name] = NewSchema()
+			}
+		case strings.HasPrefix(kw, "USE "):
+			current = unquote(toks[1])
+		case kw == "CREATE TABLE", kw == "INSERT INTO":
+			schema, name, rest := qualifiedName(toks[2:], current)
+			if schemas[schema] == nil {
+				return nil, fmt.Errorf("unknown database %q in %s", schema, strings.Join(toks, " "))
+			}
+			var err error
+			if kw == "CREATE TABLE" {
+				schemas[schema].tables[name], err = parseTable(name, rest)
+			} else {
+				err = parseInsert(schemas[schema], name, rest)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	return schemas, nil
+}
+
+// splits a script into words, quoted strings and identifiers and punctuation
+func tokenize(script string) []string {
+	toks := []string{}
+	runes := []rune(script)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		j := i + 1
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+		case r == '\'' || r == '"' || r == '`':
+			// backslashes and doubled quotes escape the next character
+			for ; j < len(runes); j++ {
+				if runes[j] == '\\' || runes[j] == r && j+1 < len(runes) && runes[j+1] == r {
+					j++
+				} else if runes[j] == r {
+					break
+				}
+			}
+			j++
+		case strings.ContainsRune("(),.;", r):
+		default:
+			for j < len(runes) && !unicode.IsSpace(runes[j]) && !strings.ContainsRune("(),.;'\"`", runes[j]) ||
+				// keep decimals in one piece
+				j+1 < len(runes) && runes[j] == '.' && unicode.IsDigit(r) && unicode.IsDigit(runes[j+1]) {
+				j++
+			}
+		}
+		if j > len(runes) {
+			j = len(runes)
+		}
+		toks = append(toks, string(runes[i:j]))
+		i = j
+	}
+	return toks
+}
+
+// splits the tokens on semicolons, leaving out empty statements
+func statements(toks []string) [][]string {
+	stmts := [][]string{}
+	start := 0
+	for i := 0; i <= len(toks); i++ {
+		if i == len(toks) || toks[i] == ";" {
+			if i > start {
+				stmts = append(stmts, toks[start:i])
+			}
+			start = i + 1
+		}
+	}
+	return stmts
+}
+
+func unquote(tok string) string {
+	if len(tok) < 2 || !strings.ContainsRune("'\"`", rune(tok[0])) || tok[len(tok)-1] != tok[0] {
+		return tok
+	}
+	q := string(tok[0])
+	s := strings.ReplaceAll(tok[1:len(tok)-1], q+q, q)
+	if q != "`" {
+		s = strings.NewReplacer(`\\`, `\`, `\'`, `'`, `\"`, `"`, `\n`, "\n", `\t`, "\t", `\0`, "\x00").Replace(s)
+	}
+	return s
+}
+
+// reads a table name that may be qualified by its schema
+func qualifiedName(toks []string, current string) (string, string, []string) {
+	if len(toks) >= 3 && toks[1] == "." {
+		return unquote(toks[0]), unquote(toks[2]), toks[3:]
+	}
+	return current, unquote(toks[0]), toks[1:]
+}
+
+// splits the tokens of a parenthesized list on its top level commas, it returns the items and the tokens
+// after the closing parenthesis
+func parenList(toks []string) ([][]string, []string, error) {
+	if len(toks) == 0 || toks[0] != "(" {
+		return nil, nil, fmt.Errorf("expected ( at %v", toks)
+	}
+	items := [][]string{{}}
+	depth := 0
+	for i, tok := range toks[1:] {
+		switch {
+		case tok == ")" && depth == 0:
+			return items, toks[i+2:], nil
+		case tok == "," && depth == 0:
+			items = append(items, []string{})
+			continue
+		case tok == "(":
+			depth++
+		case tok == ")":
+			depth--
+		}
+		items[len(items)-1] = append(items[len(items)-1], tok)
+	}
+	return nil, nil, fmt.Errorf("unbalanced parenthesis")
+}
+
+// reads a parenthesized list of column names
+func identList(toks []string) ([]string, []string, error) {
+	items, rest, err := parenList(toks)
+	if err != nil {
+		return nil, nil, err
+	}
+	idents := []string{}
+	for _, item := range items {
+		idents = append(idents, unquote(item[0]))
+	}
+	return idents, rest, nil
+}
+
+func parseTable(name string, toks []string) (*table, error) {
+	defs, _, err := parenList(toks)
+	if err != nil {
+		return nil, err
+	}
+	t := &table{}
+	for _, def := range defs {
+		if len(def) > 0 && strings.ToUpper(def[0]) == "CONSTRAINT" {
+			// CONSTRAINT [name] FOREIGN KEY ...
+			for len(def) > 0 && strings.ToUpper(def[0]) != "FOREIGN" && strings.ToUpper(def[0]) != "PRIMARY" {
+				def = def[1:]
+			}
+		}
+		if len(def) == 0 {
+			continue
+		}
+		switch strings.ToUpper(def[0]) {
+		case "PRIMARY":
+			t.pk, _, err = identList(def[2:])
+			if err != nil {
+				return nil, err
+			}
+		case "FOREIGN":
+			cols, rest, err := identList(def[2:])
+			if err != nil {
+				return nil, err
+			}
+			if len(rest) < 2 || strings.ToUpper(rest[0]) != "REFERENCES" {
+				return nil, fmt.Errorf("expected REFERENCES at %v", rest)
+			}
+			_, refTable, rest := qualifiedName(rest[1:], "")
+			refCols, _, err := identList(rest)
+			if err != nil {
+				return nil, err
+			}
+			for i, col := range cols {
+				t.fks = append(t.fks, sampledb.ForeignKey{Table: name, Column: col, ReferencedTable: refTable, ReferencedColumn: refCols[i]})
+			}
+		case "UNIQUE", "KEY", "INDEX", "FULLTEXT", "SPATIAL", "CHECK":
+		default:
+			t.columns = append(t.columns, unquote(def[0]))
+		}
+	}
+	return t, nil
+}
+
+func parseInsert(schema *Schema, name string, toks []string) error {
+	t, err := schema.table(name)
+	if err != nil {
+		return err
+	}
+	columns := t.columns
+	if len(toks) > 0 && toks[0] == "(" {
+		columns, toks, err = identList(toks)
+		if err != nil {
+			return err
+		}
+	}
+	if len(toks) == 0 || !strings.HasPrefix(strings.ToUpper(toks[0]), "VALUE") {
+		return fmt.Errorf("expected VALUES at %v", toks)
+	}
+	for toks = toks[1:]; len(toks) > 0; {
+		vals, rest, err := parenList(toks)
+		if err != nil {
+			return err
+		}
+		if len(vals) != len(columns) {
+			return fmt.Errorf("%d values for %d columns", len(vals), len(columns))
+		}
+		row := map[string]interface{}{}
+		for i, val := range vals {
+			if len(val) == 1 && strings.ToUpper(val[0]) == "NULL" {
+				row[columns[i]] = nil
+			} else {
+				row[columns[i]] = unquote(strings.Join(val, ""))
+			}
+		}
+		t.rows = append(t.rows, row)
+		toks = rest
+		if len(toks) > 0 && toks[0] == "," {
+			toks = toks[1:]
+		}
+	}
+	return nil
+}
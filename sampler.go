@@ -0,0 +1,923 @@
+package sampledb
+
+import (
+	"context"
+	crand "crypto/rand"
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// how many rows we pick from the anchor table when no values are given
+const randomAnchorRows = 5
+
+type sampleParams struct {
+	rand   bool
+	table  string
+	column string
+	data   []interface{}
+	// rows by the values of several columns, used instead of column and data. resolved anchors are read
+	// by their primary key
+	columns []string
+	keys    [][]interface{}
+	// the relationship we followed to get to the rows, nil for the anchor rows
+	rel *ForeignKey
+}
+
+// what CopySchema does when the sample schema exists already
+const (
+	// refuse to use it
+	IfExistsFail = "fail"
+	// drop it and create it again
+	IfExistsDrop = "drop"
+	// keep its rows, only its missing tables and views are created
+	IfExistsReuse = "reuse"
+	// empty its tables, missing tables and views are created
+	IfExistsTruncate = "truncate"
+)
+
+func validIfExists(ifExists string) bool {
+	switch ifExists {
+	case IfExistsFail, IfExistsDrop, IfExistsReuse, IfExistsTruncate:
+		return true
+	}
+	return false
+}
+
+// returns a sample schema name that's not in use, sample_db_{secs since January 1, 1970 UTC}_{random suffix}
+func uniqueSchemaName(ctx context.Context, db *sql.DB) (string, error) {
+	for {
+		suffix := make([]byte, 2)
+		_, err := crand.Read(suffix)
+		if err != nil {
+			return "", err
+		}
+		name := fmt.Sprintf("sample_db_%d_%x", time.Now().Unix(), suffix)
+		exists, err := schemaExists(ctx, db, name)
+		if err != nil || !exists {
+			return name, err
+		}
+	}
+}
+
+func schemaExists(ctx context.Context, db *sql.DB, schema string) (bool, error) {
+	var count int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM information_schema.schemata WHERE schema_name = ?;", schema).Scan(&count)
+	return count > 0, err
+}
+
+// copies tables, views and stored routines, triggers and events are copied by CopyTriggers and CopyEvents once
+// the data is in. The triggers of an existing sample schema are dropped until then.
+// ifExists tells what to do when the sample schema exists already, see IfExistsFail and friends. The no
+// sample tables can be globs or regular expressions between slashes, see Options.NoSample.
+func CopySchema(ctx context.Context, db *sql.DB, targetSchema, sampleSchema string, noSampleTables map[string]struct{}, ifExists string) error {
+	return CopySchemaWithOptions(ctx, db, targetSchema, sampleSchema, noSampleTables, ifExists, CopyOptions{})
+}
+
+// CopySchemaWithOptions is CopySchema copying the rows of the no sample tables as opts says, once the
+// schema is created so the copy doesn't hold the schema locks. When it fails the sample schema is dropped
+// if it created it.
+func CopySchemaWithOptions(ctx context.Context, db *sql.DB, targetSchema, sampleSchema string, noSampleTables map[string]struct{}, ifExists string, opts CopyOptions) error {
+	if !validIfExists(ifExists) {
+		return fmt.Errorf("unknown if exists mode %s, must be one of fail, drop, reuse or truncate", ifExists)
+	}
+	exists, err := schemaExists(ctx, db, sampleSchema)
+	if err != nil {
+		return err
+	}
+	created := !exists || ifExists == IfExistsDrop
+	fullCopy, err := copySchema(ctx, db, targetSchema, sampleSchema, noSampleTables, ifExists, opts)
+	if err == nil {
+		err = CopyTables(ctx, db, targetSchema, sampleSchema, fullCopy, opts)
+	}
+	if err != nil && created {
+		// a half created schema would make the next run fail
+		_, dropErr := db.ExecContext(context.Background(), fmt.Sprintf("DROP DATABASE IF EXISTS `%s`;", sampleSchema))
+		if dropErr != nil {
+			return fmt.Errorf("drop err: %s, %w", dropErr, err)
+		}
+	}
+	return err
+}
+
+// creates the sample schema objects and returns the no sample tables whose rows have to be copied
+func copySchema(ctx context.Context, db *sql.DB, targetSchema, sampleSchema string, noSampleTables map[string]struct{}, ifExists string, opts CopyOptions) ([]string, error) {
+	exists, err := schemaExists(ctx, db, sampleSchema)
+	if err != nil {
+		return nil, err
+	}
+	sampleTables := map[string]string{}
+	if exists {
+		switch ifExists {
+		case IfExistsFail:
+			return nil, fmt.Errorf("sample schema %s already exists", sampleSchema)
+		case IfExistsDrop:
+			_, err = db.ExecContext(ctx, fmt.Sprintf("DROP DATABASE `%s`;", sampleSchema))
+			if err != nil {
+				return nil, fmt.Errorf("drop sample schema: %w", err)
+			}
+			exists = false
+		case IfExistsReuse, IfExistsTruncate:
+			sampleTables, err = showFullTables(ctx, db, sampleSchema)
+			if err != nil {
+				return nil, fmt.Errorf("show sample tables: %w", err)
+			}
+		default:
+			return nil, fmt.Errorf("unknown if exists mode %s", ifExists)
+		}
+	}
+	// the tables copied in full may be given as patterns
+	noSample, err := resolveTables(ctx, db, targetSchema, setKeys(noSampleTables))
+	if err != nil {
+		return nil, fmt.Errorf("tables copied in full: %w", err)
+	}
+	noSampleTables = map[string]struct{}{}
+	for _, table := range noSample {
+		noSampleTables[table] = struct{}{}
+	}
+	selected, err := selectTables(ctx, db, targetSchema, opts.Include, opts.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	// kept foreign keys can only reference the tables the sample schema ends up with
+	sampleSchemaTables := setOf(sampleTables)
+	for table := range selected {
+		sampleSchemaTables[table] = struct{}{}
+	}
+	if exists {
+		// the triggers of a reused schema would fire on the copied rows
+		err = dropTriggers(ctx, db, sampleSchema)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// tables emptied by truncate that are copied in full have to be copied again
+	truncated := map[string]struct{}{}
+	if exists && ifExists == IfExistsTruncate {
+		truncated, err = truncateTables(ctx, db, sampleSchema, sampleTables)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := db.Query(fmt.Sprintf("SHOW FULL TABLES FROM `%s`;", targetSchema))
+	if err != nil {
+		return nil, fmt.Errorf("show tables: %w", err)
+	}
+	defer rows.Close()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	// a no-op once committed
+	defer tx.Rollback()
+	if !exists {
+		_, err = tx.Exec(fmt.Sprintf("CREATE DATABASE `%s`;", sampleSchema))
+		if err != nil {
+			return nil, fmt.Errorf("rollback err: %s, create db: %w", tx.Rollback(), err)
+		}
+	}
+	if opts.KeepForeignKeys {
+		// tables are created in name order, before the tables they reference
+		_, err = tx.Exec("SET foreign_key_checks = 0;")
+		if err != nil {
+			return nil, fmt.Errorf("rollback err: %s, %w", tx.Rollback(), err)
+		}
+	}
+	// we create the views after creating the tables
+	views, fullCopy, excluded := []string{}, []string{}, map[string]struct{}{}
+	for rows.Next() {
+		var tableName, tableType string
+		err = rows.Scan(&tableName, &tableType)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := selected[tableName]; !ok {
+			if _, exists := sampleTables[tableName]; !exists {
+				excluded[tableName] = struct{}{}
+			}
+			continue
+		}
+		if _, isTruncated := truncated[tableName]; !isTruncated {
+			if _, exists := sampleTables[tableName]; exists {
+				continue
+			}
+		}
+		switch tableType {
+		case "VIEW":
+			views = append(views, tableName)
+		case "BASE TABLE":
+			if _, isTruncated := truncated[tableName]; !isTruncated {
+				err = createTable(ctx, db, tx, targetSchema, sampleSchema, tableName, sampleSchemaTables, opts)
+				if err != nil {
+					return nil, fmt.Errorf("create table %s: %w", tableName, err)
+				}
+			}
+			if _, exists := noSampleTables[tableName]; exists {
+				fullCopy = append(fullCopy, tableName)
+			}
+		default:
+			return nil, fmt.Errorf("unknown table type %s", tableType)
+		}
+	}
+	// views may call stored functions
+	err = copyRoutines(ctx, db, targetSchema, sampleSchema)
+	if err != nil {
+		return nil, fmt.Errorf("rollback err: %s, copy routines: %w", tx.Rollback(), err)
+	}
+	err = copyViews(ctx, db, targetSchema, sampleSchema, views, excluded)
+	if err != nil {
+		return nil, fmt.Errorf("rollback err: %s, copy views: %w", tx.Rollback(), err)
+	}
+	if opts.KeepForeignKeys {
+		// the connection goes back to the pool
+		_, err = tx.Exec("SET foreign_key_checks = 1;")
+		if err != nil {
+			return nil, fmt.Errorf("rollback err: %s, %w", tx.Rollback(), err)
+		}
+	}
+	return fullCopy, tx.Commit()
+}
+
+// empties the base tables among the schema tables and returns their names
+func truncateTables(ctx context.Context, db *sql.DB, schema string, tables map[string]string) (map[string]struct{}, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	// tables referenced by foreign keys can't be truncated otherwise
+	_, err = conn.ExecContext(ctx, "SET foreign_key_checks = 0;")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.ExecContext(context.Background(), "SET foreign_key_checks = 1;")
+	truncated := map[string]struct{}{}
+	for table, tableType := range tables {
+		if tableType != "BASE TABLE" {
+			continue
+		}
+		_, err = conn.ExecContext(ctx, fmt.Sprintf("TRUNCATE TABLE `%s`.`%s`;", schema, table))
+		if err != nil {
+			return nil, fmt.Errorf("truncate %s: %w", table, err)
+		}
+		truncated[table] = struct{}{}
+	}
+	return truncated, nil
+}
+
+// returns the type of every table in the schema by table name, either BASE TABLE or VIEW
+func showFullTables(ctx context.Context, db *sql.DB, schema string) (map[string]string, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SHOW FULL TABLES FROM `%s`;", schema))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tables := map[string]string{}
+	for rows.Next() {
+		var tableName, tableType string
+		err = rows.Scan(&tableName, &tableType)
+		if err != nil {
+			return nil, err
+		}
+		tables[tableName] = tableType
+	}
+	return tables, rows.Err()
+}
+
+type primaryKeyConstraint struct {
+	table    string
+	tableCol []string
+}
+
+// get table primary key constraint
+func getTablePrimaryKeyConstraints(ctx context.Context, db *sql.DB, schema, table string) (*primaryKeyConstraint, error) {
+	rows, err := db.QueryContext(ctx,
+		"SELECT column_name FROM information_schema.key_column_usage WHERE table_name = ? AND table_schema = ? AND constraint_name = 'PRIMARY' ORDER BY ordinal_position;",
+		table, schema)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols := []string{}
+	for rows.Next() {
+		var colName string
+		err = rows.Scan(&colName)
+		if err != nil {
+			return nil, err
+		}
+		cols = append(cols, colName)
+	}
+	return &primaryKeyConstraint{table: table, tableCol: cols}, nil
+}
+
+// returns the table column names in their ordinal order
+func tableColumns(ctx context.Context, db *sql.DB, schema, table string) ([]string, error) {
+	rows, err := db.QueryContext(ctx,
+		"SELECT column_name FROM information_schema.columns WHERE table_schema = ? AND table_name = ? ORDER BY ordinal_position;",
+		schema, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols := []string{}
+	for rows.Next() {
+		var colName string
+		err = rows.Scan(&colName)
+		if err != nil {
+			return nil, err
+		}
+		cols = append(cols, colName)
+	}
+	return cols, rows.Err()
+}
+
+// returns columns and the tables that are referenced by the targetTable via FOREIGN KEY constraints
+func fowardRelationships(ctx context.Context, db *sql.DB, schema, table string) ([]ForeignKey, error) {
+	rows, err := db.QueryContext(ctx,
+		"SELECT column_name, referenced_column_name, referenced_table_name FROM information_schema.key_column_usage WHERE table_schema = ? AND table_name = ? AND referenced_table_name != 'NULL';",
+		schema, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols := map[string]struct{}{}
+	rels := []ForeignKey{}
+	for rows.Next() {
+		var colName, refColName, refTableName string
+		err = rows.Scan(&colName, &refColName, &refTableName)
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := cols[colName]; !exists {
+			cols[colName] = struct{}{}
+			rels = append(rels, ForeignKey{
+				Table: table, ReferencedTable: refTableName,
+				Column: colName, ReferencedColumn: refColName,
+			})
+		}
+	}
+	return rels, nil
+}
+
+// returns columns and the tables that reference the targetTable via FOREIGN KEY constraints
+func reverseRelationships(ctx context.Context, db *sql.DB, schema, table string) ([]ForeignKey, error) {
+	rows, err := db.QueryContext(ctx,
+		"SELECT table_name, column_name, referenced_column_name FROM information_schema.key_column_usage WHERE table_schema = ? AND referenced_table_name = ? ORDER BY table_name, column_name;",
+		schema, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rels := []ForeignKey{}
+	for rows.Next() {
+		var colName, refColName, tableName string
+		err = rows.Scan(&tableName, &colName, &refColName)
+		if err != nil {
+			return nil, err
+		}
+		rels = append(rels, ForeignKey{
+			Table: tableName, ReferencedTable: table,
+			Column: colName, ReferencedColumn: refColName,
+		})
+	}
+	return rels, nil
+}
+
+// keySet is a concurrency safe set of visited rows, keyed by table name
+type keySet struct {
+	mu   sync.Mutex
+	keys map[string]map[string]struct{}
+}
+
+func newKeySet() *keySet {
+	return &keySet{keys: make(map[string]map[string]struct{})}
+}
+
+// add marks the key as visited for the table, it returns false if it was already visited
+func (s *keySet) add(table, key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tableKeys, ok := s.keys[table]
+	if !ok {
+		tableKeys = make(map[string]struct{})
+		s.keys[table] = tableKeys
+	}
+	if _, exists := tableKeys[key]; exists {
+		return false
+	}
+	tableKeys[key] = struct{}{}
+	return true
+}
+
+// has tells if the key was visited for the table
+func (s *keySet) has(table, key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, exists := s.keys[table][key]
+	return exists
+}
+
+// snapshot returns a copy of the keys in the set by table name
+func (s *keySet) snapshot() map[string][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap := make(map[string][]string, len(s.keys))
+	for table, tableKeys := range s.keys {
+		keys := make([]string, 0, len(tableKeys))
+		for key := range tableKeys {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		snap[table] = keys
+	}
+	return snap
+}
+
+// returns the visit key for the given column values
+func keyOf(columns []string, data []interface{}) string {
+	var key string
+	for i, col := range columns {
+		val := data[i]
+		if b, ok := val.([]byte); ok {
+			val = string(b)
+		}
+		if i > 0 {
+			key += ","
+		}
+		key += fmt.Sprintf("%s=%v", col, val)
+	}
+	return key
+}
+
+// workGroup waits for a batch of jobs started by a sampler and keeps the first error returned by them
+type workGroup struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	once   sync.Once
+	err    error
+}
+
+func (g *workGroup) setErr(err error) {
+	if err == nil {
+		return
+	}
+	g.once.Do(func() {
+		g.err = err
+		g.cancel()
+	})
+}
+
+func (g *workGroup) wait() error {
+	g.wg.Wait()
+	g.cancel()
+	return g.err
+}
+
+// Sampler samples a schema, see NewSampler
+type Sampler struct {
+	opts         Options
+	db           *sqlx.DB
+	targetSchema string
+	sampleSchema string
+	// where rows are read from and written to
+	source RowSource
+	dest   RowWriter
+	// rows whose foward relationships have been followed
+	fowardVisit *keySet
+	// rows whose reverse relationships have been followed
+	sampleVisit *keySet
+	// rows from the visit sets whose relationships have been followed completely
+	fowardDone *keySet
+	sampleDone *keySet
+	// sampled rows a resumed run was walking when it was interrupted
+	pending *keySet
+	// a slot is taken for every goroutine we spawn, the calling goroutine is the last worker
+	workers chan struct{}
+	report  *Report
+	// caps the rows reverse relationships lead to
+	budget    *budget
+	budgetHit sync.Once
+
+	// where we persist our progress, checkpoints are disabled if empty
+	checkpointPath string
+	checkpointMu   sync.Mutex
+	lastCheckpoint time.Time
+	anchor         *sampleParams
+	// primary keys of the anchor rows and of the rows we sampled
+	manifestMu sync.Mutex
+	manifest   *SampleManifest
+	// manifest of the sample we're appending to, its rows were sampled by a previous run
+	appended *SampleManifest
+}
+
+func newSampler(db *sql.DB, targetSchema, sampleSchema string, workers int) *Sampler {
+	if workers < 1 {
+		workers = 1
+	}
+	s := &Sampler{
+		db:          sqlx.NewDb(db, "mysql"),
+		fowardVisit: newKeySet(),
+		sampleVisit: newKeySet(),
+		fowardDone:  newKeySet(),
+		sampleDone:  newKeySet(),
+		pending:     newKeySet(),
+		workers:     make(chan struct{}, workers-1),
+		report:      newReport(),
+		budget:      &budget{},
+	}
+	s.setSchemas(targetSchema, sampleSchema)
+	return s
+}
+
+// points the sampler to the schemas rows are read from and written to, unless the options say otherwise
+func (s *Sampler) setSchemas(targetSchema, sampleSchema string) {
+	s.targetSchema, s.sampleSchema = targetSchema, sampleSchema
+	s.source, s.dest = s.opts.Source, s.opts.Dest
+	if s.source == nil {
+		s.source = newMysqlSchema(s.db.DB, targetSchema)
+	}
+	if s.dest == nil {
+		s.dest = newMysqlWriter(s.db.DB, sampleSchema)
+	}
+}
+
+func (s *Sampler) newWorkGroup(ctx context.Context) *workGroup {
+	ctx, cancel := context.WithCancel(ctx)
+	return &workGroup{ctx: ctx, cancel: cancel}
+}
+
+// spawn runs fn on a new goroutine if there's a free worker, otherwise it runs on the calling one.
+// Jobs recurse into more jobs so we never block waiting for a worker to be released.
+func (s *Sampler) spawn(g *workGroup, fn func(ctx context.Context) error) {
+	// once a job failed the rest of the batch isn't started, it would walk rows a resumed run walks again
+	if err := g.ctx.Err(); err != nil {
+		g.setErr(err)
+		return
+	}
+	select {
+	case s.workers <- struct{}{}:
+		g.wg.Add(1)
+		go func() {
+			defer func() {
+				<-s.workers
+				g.wg.Done()
+			}()
+			g.setErr(fn(g.ctx))
+		}()
+	default:
+		g.setErr(fn(g.ctx))
+	}
+}
+
+// writes the rows of the table to the sample and records what was copied
+func (s *Sampler) write(ctx context.Context, table string, rows []map[string]interface{}) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	n, err := s.dest.WriteRows(ctx, table, rows)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		var bytes int64
+		for _, rowData := range rows {
+			bytes += rowSize(rowData)
+		}
+		// rows that were in the sample already are skipped, we don't know which ones so we go with the average
+		bytes = bytes * n / int64(len(rows))
+		s.report.copied(table, n, bytes)
+		s.budget.take(n, bytes)
+	}
+	return nil
+}
+
+// inserts all rows referenced by the rows via FOREIGN keys, the rows of each relationship are read and
+// written together
+func (s *Sampler) insertFowardRels(ctx context.Context, rels []ForeignKey, rows []map[string]interface{}) error {
+	g := s.newWorkGroup(ctx)
+	for _, rel := range rels {
+		rel := rel
+		keys, visitKeys := [][]interface{}{}, []string{}
+		for _, rowData := range rows {
+			columnData := rowData[rel.Column]
+			if columnData == nil {
+				continue
+			}
+			visitKey := keyOf([]string{rel.ReferencedColumn}, []interface{}{columnData})
+			if !s.fowardVisit.add(rel.ReferencedTable, visitKey) {
+				continue
+			}
+			keys = append(keys, []interface{}{columnData})
+			visitKeys = append(visitKeys, visitKey)
+		}
+		if len(keys) == 0 {
+			continue
+		}
+		s.spawn(g, func(ctx context.Context) error {
+			datas, err := s.source.ReadRows(ctx, rel.ReferencedTable, []string{rel.ReferencedColumn}, keys)
+			if err != nil {
+				return err
+			}
+			s.report.followed(rel, "foward", len(keys), len(datas))
+
+			moarFowRels, err := s.source.FowardRelationships(ctx, rel.ReferencedTable)
+			if err != nil {
+				return err
+			}
+			err = s.insertFowardRels(ctx, moarFowRels, datas)
+			if err != nil {
+				return err
+			}
+			err = s.write(ctx, rel.ReferencedTable, datas)
+			if err != nil {
+				return err
+			}
+			for _, visitKey := range visitKeys {
+				s.fowardDone.add(rel.ReferencedTable, visitKey)
+			}
+			return s.checkpoint(false)
+		})
+	}
+	return g.wait()
+}
+
+// reads the rows params point to
+func (s *Sampler) readParams(ctx context.Context, params *sampleParams) ([]map[string]interface{}, error) {
+	if params.rand {
+		return s.source.RandomRows(ctx, params.table, randomAnchorRows, s.opts.Seed)
+	}
+	if len(params.columns) > 0 {
+		return s.source.ReadRows(ctx, params.table, params.columns, params.keys)
+	}
+	keys := make([][]interface{}, len(params.data))
+	for i, d := range params.data {
+		keys[i] = []interface{}{d}
+	}
+	return s.source.ReadRows(ctx, params.table, []string{params.column}, keys)
+}
+
+func (s *Sampler) sample(ctx context.Context, params *sampleParams) error {
+	// we find tables we directly reference in the anchorTable via foreign keys
+	fowardRels, err := s.source.FowardRelationships(ctx, params.table)
+	if err != nil {
+		return err
+	}
+	pk, err := s.source.PrimaryKey(ctx, params.table)
+	if err != nil {
+		return err
+	}
+	ancRows, err := s.readParams(ctx, params)
+	if err != nil {
+		return err
+	}
+	datas, visitKeys := []map[string]interface{}{}, []string{}
+	for _, ancRowData := range ancRows {
+		pkData := []interface{}{}
+		for _, t := range pk {
+			pkData = append(pkData, ancRowData[t])
+		}
+		// rows reached again through a cycle have had their relationships followed already
+		visitKey := keyOf(pk, pkData)
+		if !s.sampleVisit.add(params.table, visitKey) {
+			continue
+		}
+		datas = append(datas, ancRowData)
+		visitKeys = append(visitKeys, visitKey)
+	}
+	if params.rel != nil {
+		s.report.followed(*params.rel, "reverse", len(params.data), len(ancRows))
+		// rows the interrupted run was walking got in before it stopped, the others have to fit
+		kept, keptKeys := []map[string]interface{}{}, []string{}
+		rest, restKeys := []map[string]interface{}{}, []string{}
+		for i, visitKey := range visitKeys {
+			if s.pending.has(params.table, visitKey) {
+				kept, keptKeys = append(kept, datas[i]), append(keptKeys, visitKey)
+			} else {
+				rest, restKeys = append(rest, datas[i]), append(restKeys, visitKey)
+			}
+		}
+		// the rows referencing the sample are the ones we can do without once the budget is spent
+		if n := s.budget.fit(rest); n < len(rest) {
+			s.budgetHit.Do(func() {
+				s.report.limitHit("budget: %s spent, reverse relationships were cut from %s.%s on", s.budget, params.rel.Table, params.rel.Column)
+			})
+			s.report.dropped(*params.rel, len(rest)-n)
+			// the dropped rows are done with, a resumed run mustn't walk them again
+			for _, visitKey := range restKeys[n:] {
+				s.sampleDone.add(params.table, visitKey)
+			}
+			rest, restKeys = rest[:n], restKeys[:n]
+		}
+		datas, visitKeys = append(kept, rest...), append(keptKeys, restKeys...)
+	} else if s.manifest != nil {
+		s.manifestMu.Lock()
+		s.manifest.add(params.table, pk, ancRows)
+		s.manifestMu.Unlock()
+	}
+
+	err = s.insertFowardRels(ctx, fowardRels, datas)
+	if err != nil {
+		return err
+	}
+	err = s.write(ctx, params.table, datas)
+	if err != nil {
+		return err
+	}
+
+	// we find other tables that reference the params.table via foreign keys
+	reverseRels, err := s.source.ReverseRelationships(ctx, params.table)
+	if err != nil {
+		return err
+	}
+	g := s.newWorkGroup(ctx)
+	for _, rel := range reverseRels {
+		rel := rel
+		args := []interface{}{}
+		for _, ancRowData := range datas {
+			if columnData := ancRowData[rel.ReferencedColumn]; columnData != nil {
+				args = append(args, columnData)
+			}
+		}
+		if len(args) == 0 {
+			continue
+		}
+		s.spawn(g, func(ctx context.Context) error {
+			return s.sample(ctx, &sampleParams{table: rel.Table, column: rel.Column, data: args, rel: &rel})
+		})
+	}
+	err = g.wait()
+	if err != nil {
+		return err
+	}
+	for _, visitKey := range visitKeys {
+		s.sampleDone.add(params.table, visitKey)
+	}
+	if s.manifest != nil && len(pk) > 0 {
+		s.manifestMu.Lock()
+		s.manifest.sampled(params.table, pk, datas)
+		s.manifestMu.Unlock()
+	}
+	return s.checkpoint(false)
+}
+
+// seedVisits marks the rows already in the sample as visited so we don't copy them again when they're
+// referenced by the rows we sample. Rows are visited by the columns other tables reference them by. Rows
+// in the sample may have been copied only because sampled rows referenced them, we don't know which were
+// sampled unless the manifest of the sample lists them: those aren't walked again.
+func (s *Sampler) seedVisits(ctx context.Context) error {
+	store, ok := s.dest.(SampleStore)
+	if !ok {
+		return fmt.Errorf("the rows of the sample can't be read back, appending to it needs a SampleStore")
+	}
+	tables, err := store.Tables(ctx)
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		reverseRels, err := s.source.ReverseRelationships(ctx, table)
+		if err != nil {
+			return err
+		}
+		cols := map[string]struct{}{}
+		for _, rel := range reverseRels {
+			if _, exists := cols[rel.ReferencedColumn]; exists {
+				continue
+			}
+			cols[rel.ReferencedColumn] = struct{}{}
+			vals, err := store.ReadColumns(ctx, table, []string{rel.ReferencedColumn})
+			if err != nil {
+				return err
+			}
+			for _, val := range vals {
+				visitKey := keyOf([]string{rel.ReferencedColumn}, val)
+				s.fowardVisit.add(table, visitKey)
+				s.fowardDone.add(table, visitKey)
+			}
+		}
+	}
+	if s.appended != nil {
+		for table, mk := range s.appended.Sampled {
+			for _, key := range mk.Keys {
+				visitKey := keyOf(mk.Columns, key)
+				s.sampleVisit.add(table, visitKey)
+				s.sampleDone.add(table, visitKey)
+			}
+		}
+	}
+	return nil
+}
+
+// returns the anchor params with the rows we'll start from resolved to their primary key values, random
+// anchors wouldn't pick the same rows again if we had to resume
+func (s *Sampler) resolveAnchor(ctx context.Context, params *sampleParams) (*sampleParams, error) {
+	if !params.rand {
+		return params, nil
+	}
+	pk, err := s.source.PrimaryKey(ctx, params.table)
+	if err != nil {
+		return nil, err
+	}
+	if len(pk) == 0 {
+		return nil, fmt.Errorf("table %s has no primary key", params.table)
+	}
+	rows, err := s.source.RandomRows(ctx, params.table, randomAnchorRows, s.opts.Seed)
+	if err != nil {
+		return nil, err
+	}
+	resolved := &sampleParams{table: params.table, columns: pk}
+	for _, rowData := range rows {
+		key := make([]interface{}, len(resolved.columns))
+		for i, col := range resolved.columns {
+			key[i] = rowData[col]
+			if b, ok := key[i].([]byte); ok {
+				key[i] = string(b)
+			}
+		}
+		resolved.keys = append(resolved.keys, key)
+	}
+	if len(resolved.keys) == randomAnchorRows {
+		s.report.limitHit("anchor: picked %d random rows of %s", randomAnchorRows, params.table)
+	}
+	return resolved, nil
+}
+
+// run samples the db starting from the anchor params
+func (s *Sampler) run(ctx context.Context, params *sampleParams) error {
+	params, err := s.resolveAnchor(ctx, params)
+	if err != nil {
+		return err
+	}
+	s.anchor = params
+	s.manifest = &SampleManifest{Seed: s.opts.Seed, TargetSchema: s.targetSchema, Tables: map[string]*ManifestKeys{}}
+	err = s.checkpoint(true)
+	if err != nil {
+		return err
+	}
+	err = s.sample(ctx, params)
+	if err != nil {
+		// keep what we've done so far so we can resume from there
+		if cerr := s.checkpoint(true); cerr != nil {
+			log.Printf("could not save checkpoint: %s\n", cerr)
+		}
+		return err
+	}
+	return s.removeCheckpoint()
+}
+
+// replay copies the rows of the manifest and the rows they reference, their reverse relationships aren't
+// followed so the sample holds exactly the manifest rows and what they need
+func (s *Sampler) replay(ctx context.Context, m *SampleManifest) error {
+	s.manifest = &SampleManifest{Seed: m.Seed, TargetSchema: s.targetSchema, Tables: map[string]*ManifestKeys{}}
+	tables := make([]string, 0, len(m.Tables))
+	for table := range m.Tables {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		mk := m.Tables[table]
+		pk, err := s.source.PrimaryKey(ctx, table)
+		if err != nil {
+			return err
+		}
+		if len(pk) == 0 || strings.Join(mk.Columns, ",") != strings.Join(pk, ",") {
+			return fmt.Errorf("manifest keys of %s are on %s, its primary key is %s", table, strings.Join(mk.Columns, ", "), strings.Join(pk, ", "))
+		}
+		keys := map[string]struct{}{}
+		for _, key := range mk.Keys {
+			if len(key) != len(mk.Columns) {
+				return fmt.Errorf("manifest key %v of %s doesn't have a value for each of %s", key, table, strings.Join(mk.Columns, ", "))
+			}
+			keys[keyOf(mk.Columns, key)] = struct{}{}
+		}
+		rows, err := s.source.ReadRows(ctx, table, mk.Columns, mk.Keys)
+		if err != nil {
+			return err
+		}
+		if len(rows) != len(keys) {
+			return fmt.Errorf("%d of the %d manifest keys of %s aren't in %s", len(keys)-len(rows), len(keys), table, s.targetSchema)
+		}
+		fowardRels, err := s.source.FowardRelationships(ctx, table)
+		if err != nil {
+			return err
+		}
+
+		err = s.insertFowardRels(ctx, fowardRels, rows)
+		if err != nil {
+			return err
+		}
+		err = s.write(ctx, table, rows)
+		if err != nil {
+			return err
+		}
+		s.manifest.add(table, mk.Columns, rows)
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+DROP DATABASE IF EXISTS export;
+CREATE DATABASE IF NOT EXISTS export;
+use export;
+
+CREATE TABLE customers (
+    id          INT             NOT NULL,
+    name        VARCHAR(40)     NOT NULL,
+    PRIMARY KEY (id)
+);
+
+CREATE TABLE orders (
+    id          INT             NOT NULL,
+    customer_id INT             NOT NULL,
+    total       DECIMAL(10,2)   NOT NULL,
+    placed_on   DATE            NOT NULL,
+    placed_at   DATETIME        NOT NULL,
+    note        VARCHAR(100),
+    receipt     BLOB,
+    attrs       JSON,
+    FOREIGN KEY (customer_id) REFERENCES customers (id),
+    PRIMARY KEY (id)
+);
+
+INSERT INTO customers VALUES (1, 'Ann "the" Buyer'), (2, '');
+INSERT INTO orders VALUES
+    (10, 1, 12.50, '2020-01-02', '2020-01-02 10:11:12', 'gift, wrapped', 'hi', '{"rush": true}'),
+    (11, 2, 99999999.99, '2020-02-03', '2020-02-03 00:00:00', NULL, NULL, NULL);
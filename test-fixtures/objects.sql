@@ -0,0 +1,23 @@
+DROP DATABASE IF EXISTS objects;
+CREATE DATABASE IF NOT EXISTS objects;
+use objects;
+
+CREATE TABLE employees(
+    emp_no      INT             NOT NULL,
+    first_name  VARCHAR(14)     NOT NULL,
+    PRIMARY KEY (emp_no)
+);
+
+CREATE TABLE hires (
+    emp_no      INT             NOT NULL,
+    PRIMARY KEY (emp_no)
+);
+
+CREATE TRIGGER employees_hired AFTER INSERT ON employees FOR EACH ROW INSERT INTO objects.hires VALUES (NEW.emp_no);
+
+CREATE PROCEDURE count_employees() SELECT COUNT(*) FROM objects.employees;
+
+CREATE FUNCTION double_emp_no(n INT) RETURNS INT DETERMINISTIC RETURN n * 2;
+
+INSERT INTO `employees` VALUES (10001,'Georgi'),
+(10002,'Bezalel');
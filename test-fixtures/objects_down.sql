@@ -0,0 +1 @@
+DROP DATABASE IF EXISTS objects;
\ No newline at end of file
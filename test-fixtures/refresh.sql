@@ -0,0 +1,25 @@
+DROP DATABASE IF EXISTS refresh;
+CREATE DATABASE IF NOT EXISTS refresh;
+use refresh;
+
+CREATE TABLE departments (
+    dept_no     CHAR(4)         NOT NULL,
+    dept_name   VARCHAR(40)     NOT NULL,
+    PRIMARY KEY (dept_no)
+);
+
+CREATE TABLE employees(
+    emp_no      INT             NOT NULL,
+    first_name  VARCHAR(14)     NOT NULL,
+    dept_no     CHAR(4)         NOT NULL,
+    PRIMARY KEY (emp_no),
+    FOREIGN KEY (dept_no) REFERENCES departments (dept_no)
+);
+
+INSERT INTO `departments` VALUES 
+('d001','Marketing'),
+('d002','Finance');
+
+INSERT INTO `employees` VALUES (10001,'Georgi','d001'),
+(10002,'Bezalel','d001'),
+(10003,'Parto','d002');
@@ -0,0 +1 @@
+DROP DATABASE IF EXISTS refresh;
\ No newline at end of file
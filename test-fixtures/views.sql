@@ -0,0 +1,14 @@
+DROP DATABASE IF EXISTS views;
+CREATE DATABASE IF NOT EXISTS views;
+use views;
+
+CREATE TABLE employees(
+    emp_no      INT             NOT NULL,
+    first_name  VARCHAR(14)     NOT NULL,
+    last_name   VARCHAR(16)     NOT NULL,
+    PRIMARY KEY (emp_no)
+);
+
+CREATE VIEW employee_names AS SELECT emp_no, first_name, last_name FROM views.employees;
+
+CREATE VIEW active_names AS SELECT first_name, last_name FROM employee_names;
@@ -0,0 +1 @@
+DROP DATABASE IF EXISTS views;
\ No newline at end of file
@@ -0,0 +1,169 @@
+package sampledb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Orphan is a value of a foreign key column with no row to reference in the referenced table. The columns
+// and values of composite foreign keys are separated by commas.
+type Orphan struct {
+	Table            string `json:"table"`
+	Column           string `json:"column"`
+	ReferencedTable  string `json:"referenced_table"`
+	ReferencedColumn string `json:"referenced_column"`
+	Value            string `json:"value"`
+}
+
+// Relationship is a foreign key from the columns of a table to the columns of the referenced table, in the
+// same order
+type Relationship struct {
+	Table             string
+	Columns           []string
+	ReferencedTable   string
+	ReferencedColumns []string
+}
+
+// ParseRelationship parses the table.column=referenced_table.column format, the columns of composite keys
+// are separated by commas as in orders.shop_id,customer_id=customers.shop_id,id
+func ParseRelationship(rel string) (Relationship, error) {
+	sides := strings.Split(rel, "=")
+	if len(sides) != 2 {
+		return Relationship{}, fmt.Errorf("bad format for relationship %q, expected table.column=referenced_table.column", rel)
+	}
+	parsed := make([]struct {
+		table   string
+		columns []string
+	}, 2)
+	for i, side := range sides {
+		dot := strings.Index(side, ".")
+		if dot <= 0 || dot == len(side)-1 {
+			return Relationship{}, fmt.Errorf("bad format for relationship %q, expected table.column=referenced_table.column", rel)
+		}
+		parsed[i].table = side[:dot]
+		parsed[i].columns = strings.Split(side[dot+1:], ",")
+	}
+	if len(parsed[0].columns) != len(parsed[1].columns) {
+		return Relationship{}, fmt.Errorf("relationship %q references %d columns with %d", rel, len(parsed[1].columns), len(parsed[0].columns))
+	}
+	return Relationship{Table: parsed[0].table, Columns: parsed[0].columns, ReferencedTable: parsed[1].table, ReferencedColumns: parsed[1].columns}, nil
+}
+
+// Verify checks every foreign key of the tables in schema for values referencing rows that are missing from
+// it, along with the virtual relationships the schema doesn't declare. Foreign keys are read from relSchema
+// as the tables of a sample don't have them. The columns of composite foreign keys are checked together, a
+// key with a NULL column references nothing.
+func Verify(ctx context.Context, db *sql.DB, relSchema, schema string, virtual ...Relationship) ([]Orphan, error) {
+	tables, err := showFullTables(ctx, db, schema)
+	if err != nil {
+		return nil, err
+	}
+	tableNames := []string{}
+	for table, tableType := range tables {
+		if tableType == "BASE TABLE" {
+			tableNames = append(tableNames, table)
+		}
+	}
+	sort.Strings(tableNames)
+	virtualRels := map[string][]Relationship{}
+	for _, rel := range virtual {
+		if tables[rel.Table] != "BASE TABLE" {
+			return nil, fmt.Errorf("table %s of relationship to %s doesn't exist in %s", rel.Table, rel.ReferencedTable, schema)
+		}
+		virtualRels[rel.Table] = append(virtualRels[rel.Table], rel)
+	}
+
+	orphans := []Orphan{}
+	for _, table := range tableNames {
+		rels, err := declaredRelationships(ctx, db, relSchema, table)
+		if err != nil {
+			return nil, err
+		}
+		for _, rel := range append(rels, virtualRels[table]...) {
+			_, exists := tables[rel.ReferencedTable]
+			found, err := orphanValues(ctx, db, schema, rel, exists)
+			if err != nil {
+				return nil, fmt.Errorf("check %s.%s: %w", table, strings.Join(rel.Columns, ","), err)
+			}
+			orphans = append(orphans, found...)
+		}
+	}
+	return orphans, nil
+}
+
+// returns the values of the relationship columns in schema that reference no row, every value does when
+// the referenced table doesn't exist
+func orphanValues(ctx context.Context, db *sql.DB, schema string, rel Relationship, referencedExists bool) ([]Orphan, error) {
+	cols, notNull, join := make([]string, len(rel.Columns)), make([]string, len(rel.Columns)), make([]string, len(rel.Columns))
+	for i, col := range rel.Columns {
+		cols[i] = fmt.Sprintf("c.`%s`", col)
+		notNull[i] = fmt.Sprintf("c.`%s` IS NOT NULL", col)
+		join[i] = fmt.Sprintf("c.`%s` = p.`%s`", col, rel.ReferencedColumns[i])
+	}
+	q := fmt.Sprintf("SELECT DISTINCT %s FROM `%s`.`%s` c", strings.Join(cols, ", "), schema, rel.Table)
+	where := strings.Join(notNull, " AND ")
+	if referencedExists {
+		q += fmt.Sprintf(" LEFT JOIN `%s`.`%s` p ON %s", schema, rel.ReferencedTable, strings.Join(join, " AND "))
+		where += fmt.Sprintf(" AND p.`%s` IS NULL", rel.ReferencedColumns[0])
+	}
+	rows, err := db.QueryContext(ctx, q+" WHERE "+where+";")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	orphans := []Orphan{}
+	vals := make([]sql.NullString, len(cols))
+	ptrs := make([]interface{}, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	for rows.Next() {
+		err = rows.Scan(ptrs...)
+		if err != nil {
+			return nil, err
+		}
+		value := make([]string, len(vals))
+		for i, val := range vals {
+			value[i] = val.String
+		}
+		orphans = append(orphans, Orphan{
+			Table: rel.Table, Column: strings.Join(rel.Columns, ","),
+			ReferencedTable: rel.ReferencedTable, ReferencedColumn: strings.Join(rel.ReferencedColumns, ","),
+			Value: strings.Join(value, ","),
+		})
+	}
+	return orphans, rows.Err()
+}
+
+// returns the foreign keys of the table with the columns of composite ones together, in constraint order
+func declaredRelationships(ctx context.Context, db *sql.DB, schema, table string) ([]Relationship, error) {
+	rows, err := db.QueryContext(ctx,
+		"SELECT constraint_name, column_name, referenced_table_name, referenced_column_name FROM information_schema.key_column_usage "+
+			"WHERE table_schema = ? AND table_name = ? AND referenced_table_name IS NOT NULL ORDER BY constraint_name, ordinal_position;",
+		schema, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rels := []Relationship{}
+	byConstraint := map[string]int{}
+	for rows.Next() {
+		var constraint, col, refTable, refCol string
+		err = rows.Scan(&constraint, &col, &refTable, &refCol)
+		if err != nil {
+			return nil, err
+		}
+		i, ok := byConstraint[constraint]
+		if !ok {
+			i = len(rels)
+			byConstraint[constraint] = i
+			rels = append(rels, Relationship{Table: table, ReferencedTable: refTable})
+		}
+		rels[i].Columns = append(rels[i].Columns, col)
+		rels[i].ReferencedColumns = append(rels[i].ReferencedColumns, refCol)
+	}
+	return rels, rows.Err()
+}
@@ -0,0 +1,214 @@
+package sampledb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// quoted names and string literals of a statement, literals could read like a FROM clause
+	quotedRE = regexp.MustCompile("`[^`]*`|'(?:[^'\\\\]|\\\\.|'')*'|\"(?:[^\"\\\\]|\\\\.|\"\")*\"")
+	// the table or view after a FROM or JOIN, which may be qualified by its schema, as quoted or bare names
+	tableRefRE = regexp.MustCompile("(?i)\\b(?:from|join)[\\s(]+(?:`([^`]*)`|(\\w+))(?:\\s*\\.\\s*(?:`([^`]*)`|(\\w+)))?")
+)
+
+// copyViews creates the views in the sample schema from their SHOW CREATE VIEW statement, views that select
+// from other views are created after them. Views selecting from an excluded table, or from a view that isn't
+// created, are skipped with a warning. Like routines they lose their definer, so SQL SECURITY DEFINER views
+// run with the privileges of the user running sampledb.
+// perms: requires SHOW VIEW privilege
+func copyViews(ctx context.Context, db *sql.DB, targetSchema, sampleSchema string, views []string, excluded map[string]struct{}) error {
+	objects := map[string]*schemaObject{}
+	for _, view := range views {
+		obj := &schemaObject{kind: "VIEW", name: view}
+		err := showCreate(ctx, db, targetSchema, obj)
+		if err != nil {
+			return err
+		}
+		objects[view] = obj
+	}
+	refs, err := viewTables(ctx, db, targetSchema, objects)
+	if err != nil {
+		return err
+	}
+	skipped := skippedViews(views, refs, excluded)
+	for _, view := range views {
+		if table, isSkipped := skipped[view]; isSkipped {
+			log.Printf("skipping view %s, it selects from %s which isn't copied\n", view, table)
+		}
+	}
+	deps := map[string][]string{}
+	for view := range objects {
+		if _, isSkipped := skipped[view]; isSkipped {
+			continue
+		}
+		deps[view] = []string{}
+		for _, table := range refs[view] {
+			if _, ok := objects[table]; ok && table != view {
+				deps[view] = append(deps[view], table)
+			}
+		}
+	}
+	order, err := sortByDependencies("views", deps)
+	if err != nil {
+		return err
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.ExecContext(ctx, fmt.Sprintf("USE `%s`;", sampleSchema))
+	if err != nil {
+		return err
+	}
+	for _, view := range order {
+		stmt := rewriteSchemaRefs(stripDefiner(objects[view].create), targetSchema, sampleSchema)
+		_, err = conn.ExecContext(ctx, stmt)
+		if err != nil {
+			return fmt.Errorf("create view %s: %w", view, err)
+		}
+	}
+	return nil
+}
+
+// returns the views that select from an excluded table or from a skipped view, along with the table or view
+func skippedViews(views []string, refs map[string][]string, excluded map[string]struct{}) map[string]string {
+	skipped := map[string]string{}
+	// skipping a view skips the views selecting from it in turn
+	for {
+		n := len(skipped)
+		for _, view := range views {
+			if _, isSkipped := skipped[view]; isSkipped {
+				continue
+			}
+			for _, table := range refs[view] {
+				_, isExcluded := excluded[table]
+				_, isSkipped := skipped[table]
+				if isExcluded || isSkipped {
+					skipped[view] = table
+					break
+				}
+			}
+		}
+		if len(skipped) == n {
+			return skipped
+		}
+	}
+}
+
+// returns the tables and views of the schema each view selects from. They're read from
+// information_schema.view_table_usage where the server fills it in (MySQL 8.0.13 and up) and parsed from the
+// FROM and JOIN clauses of the views otherwise.
+func viewTables(ctx context.Context, db *sql.DB, schema string, views map[string]*schemaObject) (map[string][]string, error) {
+	refs := map[string][]string{}
+	found := false
+	rows, err := db.QueryContext(ctx,
+		"SELECT view_name, table_name FROM information_schema.view_table_usage WHERE view_schema = ? AND table_schema = ? ORDER BY view_name, table_name;",
+		schema, schema)
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var view, table string
+			err = rows.Scan(&view, &table)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := views[view]; !ok {
+				continue
+			}
+			found = true
+			refs[view] = append(refs[view], table)
+		}
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if found {
+		return refs, nil
+	}
+	for view, obj := range views {
+		refs[view] = viewReferences(obj.create, schema)
+	}
+	return refs, nil
+}
+
+// returns the tables and views of schema a CREATE VIEW statement selects from, unqualified names are taken
+// to be in schema. Names are only read after FROM and JOIN, the server writes joins with commas as JOIN.
+func viewReferences(stmt, schema string) []string {
+	stmt = quotedRE.ReplaceAllStringFunc(stmt, func(quoted string) string {
+		if strings.HasPrefix(quoted, "`") {
+			return quoted
+		}
+		return "''"
+	})
+	refs := []string{}
+	for _, m := range tableRefRE.FindAllStringSubmatch(stmt, -1) {
+		if strings.EqualFold(m[2], "select") {
+			// a derived table
+			continue
+		}
+		qualifier, name := "", m[1]+m[2]
+		if m[3] != "" || m[4] != "" {
+			qualifier, name = name, m[3]+m[4]
+		}
+		if qualifier != "" && qualifier != schema {
+			continue
+		}
+		refs = append(refs, name)
+	}
+	return refs
+}
+
+// sortByDependencies orders the objects so each one comes after the ones it depends on, objects with no dependency
+// between them are sorted by name. kind names the objects in errors.
+func sortByDependencies(kind string, deps map[string][]string) ([]string, error) {
+	pending := map[string]int{}
+	dependents := map[string][]string{}
+	for obj, objDeps := range deps {
+		pending[obj] += 0
+		for _, dep := range objDeps {
+			if _, exists := deps[dep]; !exists {
+				continue
+			}
+			pending[obj]++
+			dependents[dep] = append(dependents[dep], obj)
+		}
+	}
+	ready := []string{}
+	for obj, n := range pending {
+		if n == 0 {
+			ready = append(ready, obj)
+		}
+	}
+	order := []string{}
+	for len(ready) > 0 {
+		sort.Strings(ready)
+		obj := ready[0]
+		ready = ready[1:]
+		order = append(order, obj)
+		for _, dependent := range dependents[obj] {
+			pending[dependent]--
+			if pending[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+	if len(order) != len(deps) {
+		cycle := []string{}
+		for obj, n := range pending {
+			if n > 0 {
+				cycle = append(cycle, obj)
+			}
+		}
+		sort.Strings(cycle)
+		return nil, fmt.Errorf("%s depend on each other in a cycle: %s", kind, strings.Join(cycle, ", "))
+	}
+	return order, nil
+}